@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+type enqueueRequest struct {
+	JobType        string          `json:"job_type"`
+	Queue          string          `json:"queue"`
+	TenantID       string          `json:"tenant_id"`
+	Payload        json.RawMessage `json:"payload"`
+	RunAt          time.Time       `json:"run_at"`
+	Delay          string          `json:"delay"`
+	Priority       int             `json:"priority"`
+	UniqueKey      string          `json:"unique_key"`
+	IdempotencyKey string          `json:"idempotency_key"`
+	MaxAttempts    int             `json:"max_attempts"`
+	DependsOn      []uint          `json:"depends_on"`
+}
+
+// runAdminServer serves the admin API on ADMIN_ADDR until ctx is cancelled.
+func runAdminServer(ctx context.Context, db *gorm.DB) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", enqueueHandler(db))
+	mux.HandleFunc("GET /jobs", listHandler(db))
+	mux.HandleFunc("GET /jobs/{id}", getHandler(db))
+	mux.HandleFunc("POST /jobs/{id}/retry", transitionHandler(db, retryWorker))
+	mux.HandleFunc("POST /jobs/{id}/cancel", transitionHandler(db, cancelWorker))
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /nodes", listNodesHandler(db))
+	mux.HandleFunc("GET /queues", listQueuesHandler(db))
+	mux.HandleFunc("PUT /queues/{name}", setQueueHandler(db))
+	mux.HandleFunc("GET /tenants", listTenantsHandler(db))
+	mux.HandleFunc("PUT /tenants/{name}", setTenantHandler(db))
+	mux.HandleFunc("GET /schedules", listSchedulesHandler(db))
+	mux.HandleFunc("POST /schedules", registerScheduleHandler(db))
+
+	srv := &http.Server{
+		Addr:    getEnv("ADMIN_ADDR", ":8080"),
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Admin server listening on %s\n", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println("Admin server failed:", err)
+	}
+}
+
+func enqueueHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req enqueueRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		opts := EnqueueOptions{
+			Queue:          req.Queue,
+			Tenant:         req.TenantID,
+			RunAt:          req.RunAt,
+			Priority:       req.Priority,
+			UniqueKey:      req.UniqueKey,
+			IdempotencyKey: req.IdempotencyKey,
+			DependsOn:      req.DependsOn,
+			MaxAttempts:    req.MaxAttempts,
+		}
+		if req.Delay != "" {
+			delay, err := time.ParseDuration(req.Delay)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			opts.Delay = delay
+		}
+
+		var payload any
+		if len(req.Payload) > 0 {
+			payload = req.Payload
+		}
+
+		worker, err := Enqueue(r.Context(), db, req.JobType, payload, opts)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, worker)
+	}
+}
+
+func listHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+				return
+			}
+			limit = parsed
+		}
+
+		workers, err := listWorkers(r.Context(), db, r.URL.Query().Get("status"), limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, workers)
+	}
+}
+
+func getHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid id"))
+			return
+		}
+
+		worker, err := getWorker(r.Context(), db, uint(id))
+		switch {
+		case errors.Is(err, ErrWorkerNotFound):
+			writeError(w, http.StatusNotFound, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, worker)
+		}
+	}
+}
+
+func listNodesHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		heartbeats, err := listHeartbeats(r.Context(), db)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, heartbeats)
+	}
+}
+
+func listQueuesHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		configs, err := listQueueConfigs(r.Context(), db)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, configs)
+	}
+}
+
+func setQueueHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var config QueueConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		config.Name = r.PathValue("name")
+
+		saved, err := SetQueueConfig(r.Context(), db, config)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	}
+}
+
+func listTenantsHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenants, err := listTenants(r.Context(), db)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tenants)
+	}
+}
+
+func setTenantHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var tenant Tenant
+		if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		saved, err := SetTenantWeight(r.Context(), db, r.PathValue("name"), tenant.Weight)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	}
+}
+
+type scheduleRequest struct {
+	Name     string          `json:"name"`
+	CronExpr string          `json:"cron_expr"`
+	JobType  string          `json:"job_type"`
+	Payload  json.RawMessage `json:"payload"`
+	Priority int             `json:"priority"`
+}
+
+func listSchedulesHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedules, err := listSchedules(r.Context(), db)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, schedules)
+	}
+}
+
+func registerScheduleHandler(db *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req scheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Name == "" || req.JobType == "" {
+			writeError(w, http.StatusBadRequest, errors.New("name and job_type are required"))
+			return
+		}
+
+		var payload any
+		if len(req.Payload) > 0 {
+			payload = req.Payload
+		}
+
+		schedule, err := RegisterSchedule(r.Context(), db, req.Name, req.CronExpr, req.JobType, payload, req.Priority)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, schedule)
+	}
+}
+
+// transitionHandler wraps a status change of the row in the {id} path value.
+func transitionHandler(db *gorm.DB, transition func(context.Context, *gorm.DB, uint) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid id"))
+			return
+		}
+
+		switch err := transition(r.Context(), db, uint(id)); {
+		case errors.Is(err, ErrWorkerNotFound):
+			writeError(w, http.StatusNotFound, err)
+		case errors.Is(err, ErrInvalidStatus):
+			writeError(w, http.StatusConflict, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// auditDuplicate is a job with more than one worker log, i.e. processed to the
+// end more than once.
+type auditDuplicate struct {
+	WorkerID    uint
+	Logs        int
+	WorkerNames string
+}
+
+// auditMismatch is a job whose status and worker logs disagree.
+type auditMismatch struct {
+	WorkerID  uint
+	Status    string
+	LogID     *uint
+	LogStatus *string
+}
+
+// auditReport lists every inconsistency between workers and worker_logs.
+type auditReport struct {
+	// Duplicates are jobs with more than one worker log.
+	Duplicates []auditDuplicate
+	// MissingLogs are finished jobs without a finished log.
+	MissingLogs []auditMismatch
+	// OrphanLogs are logs whose job is not in the status they record.
+	OrphanLogs []auditMismatch
+}
+
+func (r *auditReport) problems() int {
+	return len(r.Duplicates) + len(r.MissingLogs) + len(r.OrphanLogs)
+}
+
+// auditWorkers checks that every job that ended was processed exactly once:
+// one worker log per finished job, at most one per cancelled job and none for
+// any other job. Only cancellations of a running job are logged, a pending
+// job or one reaped while its cancellation was requested has no log.
+func auditWorkers(ctx context.Context, db *gorm.DB) (*auditReport, error) {
+	var report auditReport
+	db = db.WithContext(ctx)
+
+	if err := db.Model(&WorkerLog{}).
+		Select("worker_id, count(*) AS logs, string_agg(worker_name, ', ' ORDER BY id) AS worker_names").
+		Group("worker_id").
+		Having("count(*) > 1").
+		Order("worker_id").
+		Scan(&report.Duplicates).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&Worker{}).
+		Select("workers.id AS worker_id, workers.status").
+		Where("workers.status = ?", StatusFinished).
+		Where("NOT EXISTS (SELECT 1 FROM worker_logs l WHERE l.worker_id = workers.id AND l.status = workers.status)").
+		Order("workers.id").
+		Scan(&report.MissingLogs).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&WorkerLog{}).
+		Select("worker_logs.worker_id, w.status, worker_logs.id AS log_id, worker_logs.status AS log_status").
+		Joins("JOIN workers w ON w.id = worker_logs.worker_id").
+		Where("w.status <> worker_logs.status").
+		Order("worker_logs.worker_id, worker_logs.id").
+		Scan(&report.OrphanLogs).Error; err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
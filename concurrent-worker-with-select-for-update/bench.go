@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Locking strategies of the bench claim query.
+const (
+	lockSkipLocked = "skip-locked"
+	lockNoWait     = "nowait"
+	lockNone       = "none"
+)
+
+// benchOptions describe one bench run.
+type benchOptions struct {
+	// Jobs is the number of synthetic jobs to enqueue.
+	Jobs int
+	// Workers is the number of in-process worker loops.
+	Workers int
+	// Batch is the number of jobs claimed per transaction.
+	Batch int
+	// Lock is the locking strategy of the claim query.
+	Lock string
+	// Work is how long each job takes to process.
+	Work time.Duration
+}
+
+// benchResult is what a bench run measured.
+type benchResult struct {
+	Elapsed time.Duration
+	// Processed counts every time a job was processed, duplicates included.
+	Processed int
+	// Duplicates counts the extra times jobs were processed.
+	Duplicates int
+	Claims     int
+	// EmptyClaims are claims that got nothing while jobs were still pending.
+	EmptyClaims int
+	// LockErrors are claims that failed on a lock, NOWAIT errors and
+	// deadlocks.
+	LockErrors int
+	// ClaimLatencies holds the duration of every claim transaction.
+	ClaimLatencies []time.Duration
+}
+
+// Throughput is the number of distinct jobs processed per second.
+func (r *benchResult) Throughput() float64 {
+	return float64(r.Processed-r.Duplicates) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the claim latency below which p percent of the claims
+// completed.
+func (r *benchResult) Percentile(p float64) time.Duration {
+	if len(r.ClaimLatencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(r.ClaimLatencies)
+	slices.Sort(sorted)
+	return sorted[min(int(float64(len(sorted))*p/100), len(sorted)-1)]
+}
+
+// runBench enqueues opts.Jobs jobs on a queue of their own and processes them
+// with opts.Workers loops claiming with opts.Lock. The queue is capped at zero
+// concurrency so regular workers sharing the database leave it alone, and it
+// is removed with its jobs afterwards.
+func runBench(ctx context.Context, db *gorm.DB, opts benchOptions) (*benchResult, error) {
+	if !slices.Contains([]string{lockSkipLocked, lockNoWait, lockNone}, opts.Lock) {
+		return nil, fmt.Errorf("unknown lock strategy %q", opts.Lock)
+	}
+	if opts.Jobs <= 0 || opts.Workers <= 0 || opts.Batch <= 0 {
+		return nil, errors.New("jobs, workers and batch must be positive")
+	}
+
+	queue := fmt.Sprintf("bench-%d", time.Now().UnixNano())
+	zero := 0
+	if _, err := SetQueueConfig(ctx, db, QueueConfig{Name: queue, MaxConcurrency: &zero}); err != nil {
+		return nil, err
+	}
+	defer func() {
+		cleanupCtx := context.WithoutCancel(ctx)
+		db.WithContext(cleanupCtx).Where("queue = ?", queue).Delete(&Worker{})
+		db.WithContext(cleanupCtx).Where("name = ?", queue).Delete(&QueueConfig{})
+	}()
+
+	jobs := make([]Worker, opts.Jobs)
+	for i := range jobs {
+		jobs[i] = Worker{Status: StatusPending, JobType: "bench", Queue: queue, Payload: []byte("{}"), MaxAttempts: 1, RunAt: time.Now()}
+	}
+	if err := db.WithContext(ctx).CreateInBatches(jobs, 1000).Error; err != nil {
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		result    benchResult
+		processed = map[uint]int{}
+		wg        sync.WaitGroup
+		errs      = make([]error, opts.Workers)
+	)
+
+	start := time.Now()
+	for i := range opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workerName := fmt.Sprintf("%s-%d", queue, i+1)
+
+			for ctx.Err() == nil {
+				claimStart := time.Now()
+				ids, err := benchClaim(ctx, db, opts.Lock, queue, workerName, opts.Batch)
+				latency := time.Since(claimStart)
+
+				lockErr := isLockError(err)
+				if err != nil && !lockErr {
+					errs[i] = err
+					return
+				}
+
+				mu.Lock()
+				result.Claims++
+				result.ClaimLatencies = append(result.ClaimLatencies, latency)
+				if lockErr {
+					result.LockErrors++
+				}
+				mu.Unlock()
+				if lockErr {
+					continue
+				}
+
+				if len(ids) == 0 {
+					var pending int64
+					if err := db.WithContext(ctx).Model(&Worker{}).Where("queue = ? AND status = ?", queue, StatusPending).Count(&pending).Error; err != nil {
+						errs[i] = err
+						return
+					}
+					if pending == 0 {
+						return
+					}
+					mu.Lock()
+					result.EmptyClaims++
+					mu.Unlock()
+					continue
+				}
+
+				for _, id := range ids {
+					time.Sleep(opts.Work)
+					if err := benchFinish(ctx, db, workerName, id); err != nil {
+						errs[i] = err
+						return
+					}
+
+					mu.Lock()
+					processed[id]++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	if err := errors.Join(append(errs, ctx.Err())...); err != nil {
+		return nil, err
+	}
+
+	for _, n := range processed {
+		result.Processed += n
+		result.Duplicates += n - 1
+	}
+	return &result, nil
+}
+
+// benchClaim claims up to limit pending jobs of queue, like claimWorkers but
+// with the locking of the select chosen by lock. Without a lock nothing stops
+// two workers from claiming the same job.
+func benchClaim(ctx context.Context, db *gorm.DB, lock, queue, workerName string, limit int) ([]uint, error) {
+	var ids []uint
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&Worker{}).
+			Where("queue = ? AND status = ? AND run_at <= ?", queue, StatusPending, time.Now()).
+			Order("priority DESC, run_at, id").
+			Limit(limit)
+		switch lock {
+		case lockSkipLocked:
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		case lockNoWait:
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "NOWAIT"})
+		}
+		if err := query.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		return tx.Model(&Worker{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":    StatusRunning,
+			"attempts":  gorm.Expr("attempts + 1"),
+			"locked_by": workerName,
+		}).Error
+	})
+	return ids, err
+}
+
+// benchFinish marks job id finished and writes its worker log, without
+// checking the owner so a job claimed twice is also logged twice.
+func benchFinish(ctx context.Context, db *gorm.DB, workerName string, id uint) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Worker{}).Where("id = ?", id).Updates(map[string]any{
+			"status":    StatusFinished,
+			"locked_by": nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Create(&WorkerLog{WorkerID: id, Status: StatusFinished, FinishedAt: &now, WorkerName: workerName}).Error
+	})
+}
+
+// isLockError reports whether err is a NOWAIT lock failure or a deadlock.
+func isLockError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "55P03" || pgErr.Code == "40P01")
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `Usage: worker [command] [flags]
+
+Without a command the worker process is started.
+
+Commands:
+  migrate up|down [N]|status            apply, revert or list schema migrations
+  seed [-n N]                           enqueue N simulate jobs and an example schedule
+  stats                                 count jobs by status and job type
+  ls [--status STATUS] [--limit N]      list jobs, newest first
+  nodes                                 list worker loops with their last heartbeat
+  retry ID                              retry a dead job
+  purge --older-than AGE [--status S]   delete finished/dead/cancelled jobs, e.g. --older-than 7d
+  audit [--limit N]                     check every ended job was processed exactly once
+  bench [-n N] [-m M] [--lock L]        load test claiming with skip-locked, nowait or none
+`
+
+// runCLI runs the subcommand in args and returns the process exit code.
+func runCLI(args []string) int {
+	command, args := args[0], args[1:]
+
+	var cmd func(context.Context, *gorm.DB, []string) error
+	switch command {
+	case "migrate":
+		cmd = migrateCommand
+	case "seed":
+		cmd = seedCommand
+	case "stats":
+		cmd = statsCommand
+	case "ls":
+		cmd = lsCommand
+	case "nodes":
+		cmd = nodesCommand
+	case "retry":
+		cmd = retryCommand
+	case "purge":
+		cmd = purgeCommand
+	case "audit":
+		cmd = auditCommand
+	case "bench":
+		cmd = benchCommand
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+
+	cfg, err := loadDatabaseConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, err := setupDatabase(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to connect to database:", err)
+		return 1
+	}
+	// Keep the SQL log out of the command output.
+	db = db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Warn)})
+
+	if err := cmd(context.Background(), db, args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+func migrateCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: worker migrate up|down [N]|status")
+	}
+
+	switch args[0] {
+	case "up":
+		versions, err := migrateUp(ctx, db)
+		for _, version := range versions {
+			fmt.Printf("Applied migration %04d\n", version)
+		}
+		if err == nil && len(versions) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		versions, err := migrateDown(ctx, db, steps)
+		for _, version := range versions {
+			fmt.Printf("Reverted migration %04d\n", version)
+		}
+		return err
+	case "status":
+		migrations, err := loadMigrations()
+		if err != nil {
+			return err
+		}
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range migrations {
+			appliedAt := "pending"
+			if row, exist := applied[m.Version]; exist {
+				appliedAt = row.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+func seedCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	n := flags.Int("n", 50, "number of simulate jobs to enqueue")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	for range *n {
+		if _, err := Enqueue(ctx, db, "simulate", nil, EnqueueOptions{}); err != nil {
+			return err
+		}
+	}
+
+	// Echo once a minute to show that each tick runs exactly once across app1..app3
+	if _, err := RegisterSchedule(ctx, db, "echo-every-minute", "* * * * *", "echo", map[string]string{"message": "tick"}, 0); err != nil {
+		return err
+	}
+
+	fmt.Printf("Enqueued %d simulate job(s) and the echo-every-minute schedule\n", *n)
+	return nil
+}
+
+func statsCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	var rows []struct {
+		JobType string
+		Status  string
+		Count   int64
+	}
+	if err := db.WithContext(ctx).Model(&Worker{}).
+		Select("job_type, status, count(*) AS count").
+		Group("job_type, status").
+		Order("job_type, status").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	totals := map[string]int64{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB TYPE\tSTATUS\tCOUNT")
+	for _, row := range rows {
+		totals[row.Status] += row.Count
+		fmt.Fprintf(w, "%s\t%s\t%d\n", row.JobType, row.Status, row.Count)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "STATUS\tCOUNT")
+	for _, status := range []string{StatusPending, StatusRunning, StatusCancelRequested, StatusFinished, StatusDead, StatusCancelled} {
+		fmt.Fprintf(w, "%s\t%d\n", status, totals[status])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var oldest []Worker
+	if err := db.WithContext(ctx).
+		Where("status = ? AND run_at <= ?", StatusPending, time.Now()).
+		Order("run_at").
+		Limit(1).
+		Find(&oldest).Error; err != nil {
+		return err
+	}
+	if len(oldest) == 0 {
+		return nil
+	}
+	fmt.Printf("\nOldest due pending job: ID %d, waiting %s\n", oldest[0].ID, time.Since(oldest[0].RunAt).Round(time.Second))
+	return nil
+}
+
+func lsCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	status := flags.String("status", "", "only list jobs with this status")
+	limit := flags.Int("limit", 50, "maximum number of jobs to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	workers, err := listWorkers(ctx, db, *status, *limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tJOB TYPE\tPROGRESS\tATTEMPTS\tRUN AT\tLOCKED BY\tLAST ERROR")
+	for _, worker := range workers {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d%%\t%d/%d\t%s\t%s\t%s\n",
+			worker.ID, worker.Status, worker.JobType, worker.Progress, worker.Attempts, worker.MaxAttempts,
+			worker.RunAt.Format(time.RFC3339), deref(worker.LockedBy), deref(worker.LastError))
+	}
+	return w.Flush()
+}
+
+func nodesCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	heartbeats, err := listHeartbeats(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tHOST\tSTARTED AT\tLAST SEEN\tCURRENT JOB")
+	for _, heartbeat := range heartbeats {
+		state := "stale"
+		if heartbeat.Live {
+			state = "live"
+		}
+		currentJob := "-"
+		if heartbeat.CurrentJobID != nil {
+			currentJob = strconv.FormatUint(uint64(*heartbeat.CurrentJobID), 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s ago\t%s\n",
+			heartbeat.Name, state, heartbeat.Host, heartbeat.StartedAt.Format(time.RFC3339),
+			time.Since(heartbeat.LastSeen).Round(time.Second), currentJob)
+	}
+	return w.Flush()
+}
+
+func retryCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: worker retry ID")
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[0])
+	}
+
+	if err := retryWorker(ctx, db, uint(id)); err != nil {
+		return err
+	}
+	fmt.Printf("Worker ID %d is pending again\n", id)
+	return nil
+}
+
+func purgeCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	olderThan := flags.String("older-than", "", "minimum age since the last update, e.g. 12h or 7d")
+	status := flags.String("status", "finished,dead,cancelled", "comma separated statuses to purge")
+	idempotent := flags.Bool("idempotent", false, "also purge jobs with an idempotency key, enqueueing the key again then creates a new job")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *olderThan == "" {
+		return errors.New("--older-than is required")
+	}
+
+	age, err := parseAge(*olderThan)
+	if err != nil {
+		return err
+	}
+
+	statuses := strings.Split(*status, ",")
+	for _, s := range statuses {
+		if s == StatusPending || slices.Contains(claimedStatuses, s) {
+			return fmt.Errorf("refusing to purge %s jobs", s)
+		}
+	}
+
+	query := db.WithContext(ctx).Where("status IN ? AND updated_at < ?", statuses, time.Now().Add(-age))
+	if !*idempotent {
+		// Like retention, the row is what makes enqueueing the key again
+		// return it.
+		query = query.Where("idempotency_key IS NULL")
+	}
+
+	// worker_logs go with their worker through ON DELETE CASCADE.
+	res := query.Delete(&Worker{})
+	if res.Error != nil {
+		return res.Error
+	}
+	fmt.Printf("Purged %d job(s)\n", res.RowsAffected)
+	return nil
+}
+
+func auditCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	limit := flags.Int("limit", 20, "maximum number of rows to print per check")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := auditWorkers(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Jobs processed more than once: %d\n", len(report.Duplicates))
+	if len(report.Duplicates) > 0 {
+		fmt.Fprintln(w, "WORKER ID\tLOGS\tWORKER NAMES")
+		for _, d := range report.Duplicates[:min(*limit, len(report.Duplicates))] {
+			fmt.Fprintf(w, "%d\t%d\t%s\n", d.WorkerID, d.Logs, d.WorkerNames)
+		}
+	}
+
+	fmt.Fprintf(w, "\nFinished jobs without a log: %d\n", len(report.MissingLogs))
+	if len(report.MissingLogs) > 0 {
+		fmt.Fprintln(w, "WORKER ID\tSTATUS")
+		for _, m := range report.MissingLogs[:min(*limit, len(report.MissingLogs))] {
+			fmt.Fprintf(w, "%d\t%s\n", m.WorkerID, m.Status)
+		}
+	}
+
+	fmt.Fprintf(w, "\nLogs that do not match the job status: %d\n", len(report.OrphanLogs))
+	if len(report.OrphanLogs) > 0 {
+		fmt.Fprintln(w, "LOG ID\tLOG STATUS\tWORKER ID\tJOB STATUS")
+		for _, m := range report.OrphanLogs[:min(*limit, len(report.OrphanLogs))] {
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\n", *m.LogID, deref(m.LogStatus), m.WorkerID, m.Status)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if n := report.problems(); n > 0 {
+		return fmt.Errorf("audit found %d problem(s)", n)
+	}
+	fmt.Println("\nNo problems found")
+	return nil
+}
+
+func benchCommand(ctx context.Context, db *gorm.DB, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var opts benchOptions
+	flags.IntVar(&opts.Jobs, "n", 1000, "number of jobs to enqueue")
+	flags.IntVar(&opts.Workers, "m", 8, "number of in-process workers")
+	flags.IntVar(&opts.Batch, "batch", 1, "jobs claimed per transaction")
+	flags.StringVar(&opts.Lock, "lock", lockSkipLocked, "locking of the claim query: skip-locked, nowait or none")
+	flags.DurationVar(&opts.Work, "work", 0, "time spent processing each job")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Lock errors are expected and counted, keep them out of the output.
+	db = db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Silent)})
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Processing %d job(s) with %d worker(s), batch %d, lock %s\n", opts.Jobs, opts.Workers, opts.Batch, opts.Lock)
+	result, err := runBench(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Elapsed\t%s\n", result.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput\t%.1f jobs/s\n", result.Throughput())
+	fmt.Fprintf(w, "Claims\t%d\n", result.Claims)
+	fmt.Fprintf(w, "Claim latency p50\t%s\n", result.Percentile(50))
+	fmt.Fprintf(w, "Claim latency p95\t%s\n", result.Percentile(95))
+	fmt.Fprintf(w, "Claim latency p99\t%s\n", result.Percentile(99))
+	fmt.Fprintf(w, "Claim latency max\t%s\n", result.Percentile(100))
+	fmt.Fprintf(w, "Empty claims\t%d\n", result.EmptyClaims)
+	fmt.Fprintf(w, "Lock errors\t%d\n", result.LockErrors)
+	fmt.Fprintf(w, "Processed\t%d\n", result.Processed)
+	fmt.Fprintf(w, "Duplicates\t%d\n", result.Duplicates)
+	return w.Flush()
+}
+
+// parseAge is time.ParseDuration with an extra "d" unit for days.
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func deref(value *string) string {
+	if value == nil {
+		return "-"
+	}
+	return *value
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// DatabaseConfig holds the connection and pool settings. Values come from the
+// JSON file in CONFIG_FILE when set, then DB_* env vars override them.
+type DatabaseConfig struct {
+	// DSN is used as is when set, the individual fields below are ignored.
+	DSN      string `json:"dsn"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	SSLMode  string `json:"sslmode"`
+
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime duration `json:"conn_max_lifetime"`
+	LogLevel        string   `json:"log_level"`
+}
+
+// duration reads a time.Duration from a JSON string such as "30m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+var (
+	sslModes  = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+	logLevels = map[string]logger.LogLevel{
+		"silent": logger.Silent,
+		"error":  logger.Error,
+		"warn":   logger.Warn,
+		"info":   logger.Info,
+	}
+)
+
+func defaultDatabaseConfig() DatabaseConfig {
+	return DatabaseConfig{
+		Host:            "postgres",
+		Port:            5432,
+		User:            "worker",
+		Password:        "password",
+		Name:            "workerdb",
+		SSLMode:         "disable",
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: duration(30 * time.Minute),
+		LogLevel:        "info",
+	}
+}
+
+// loadDatabaseConfig layers defaults, CONFIG_FILE and DB_* env vars, and
+// validates the result.
+func loadDatabaseConfig() (DatabaseConfig, error) {
+	cfg := defaultDatabaseConfig()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("read config file: %w", err)
+		}
+		// A misspelled key would silently keep its default.
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	overrideString := func(key string, target *string) {
+		if value := os.Getenv(key); value != "" {
+			*target = value
+		}
+	}
+	overrideInt := func(key string, target *int) {
+		if value := os.Getenv(key); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", key, value))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	overrideString("DB_DSN", &cfg.DSN)
+	overrideString("DB_HOST", &cfg.Host)
+	overrideInt("DB_PORT", &cfg.Port)
+	overrideString("DB_USER", &cfg.User)
+	overrideString("DB_PASSWORD", &cfg.Password)
+	overrideString("DB_NAME", &cfg.Name)
+	overrideString("DB_SSLMODE", &cfg.SSLMode)
+	overrideInt("DB_MAX_OPEN_CONNS", &cfg.MaxOpenConns)
+	overrideInt("DB_MAX_IDLE_CONNS", &cfg.MaxIdleConns)
+	overrideString("DB_LOG_LEVEL", &cfg.LogLevel)
+	if value := os.Getenv("DB_CONN_MAX_LIFETIME"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("DB_CONN_MAX_LIFETIME: %q is not a duration", value))
+		} else {
+			cfg.ConnMaxLifetime = duration(parsed)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return cfg, fmt.Errorf("invalid database config:\n%w", err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate reports every invalid setting at once.
+func (c DatabaseConfig) Validate() error {
+	var errs []error
+
+	if c.DSN == "" {
+		if c.Host == "" {
+			errs = append(errs, errors.New("host is required"))
+		}
+		if c.Port <= 0 || c.Port > 65535 {
+			errs = append(errs, fmt.Errorf("port %d is out of range", c.Port))
+		}
+		if c.User == "" {
+			errs = append(errs, errors.New("user is required"))
+		}
+		if c.Name == "" {
+			errs = append(errs, errors.New("name is required"))
+		}
+		if !slices.Contains(sslModes, c.SSLMode) {
+			errs = append(errs, fmt.Errorf("sslmode %q must be one of %s", c.SSLMode, strings.Join(sslModes, ", ")))
+		}
+	}
+	if c.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("max_open_conns must not be negative"))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("max_idle_conns must not be negative"))
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("max_idle_conns %d exceeds max_open_conns %d", c.MaxIdleConns, c.MaxOpenConns))
+	}
+	if c.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("conn_max_lifetime must not be negative"))
+	}
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		errs = append(errs, fmt.Errorf("log_level %q must be one of silent, error, warn, info", c.LogLevel))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid database config:\n%w", err)
+	}
+	return nil
+}
+
+// ConnString returns DSN, or builds one from the individual fields.
+func (c DatabaseConfig) ConnString() string {
+	if c.DSN != "" {
+		return c.DSN
+	}
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=UTC",
+		quoteConnValue(c.Host), quoteConnValue(c.User), quoteConnValue(c.Password),
+		quoteConnValue(c.Name), c.Port, quoteConnValue(c.SSLMode))
+}
+
+// quoteConnValue quotes a keyword/value connection string value, so spaces,
+// quotes and backslashes in a password survive.
+func quoteConnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
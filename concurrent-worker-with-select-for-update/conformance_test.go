@@ -0,0 +1,758 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// conformanceCase checks one piece of the Queue contract against a fresh
+// queue. Jobs must be enqueued on queue, and keys prefixed with it, so a
+// shared database does not leak state between cases or runs.
+type conformanceCase struct {
+	name string
+	run  func(ctx context.Context, q Queue, queue string) error
+}
+
+var conformanceCases = []conformanceCase{
+	{"claim marks due jobs running", conformanceClaim},
+	{"claim never hands out a job twice", conformanceExclusiveClaim},
+	{"claim orders by priority then run_at", conformanceOrder},
+	{"claim skips jobs scheduled later", conformanceRunAt},
+	{"claim takes turns between tenants", conformanceTenants},
+	{"claim across tenants hands out each job once", conformanceTenantsBatch},
+	{"complete needs the lease", conformanceComplete},
+	{"fail retries with a backoff", conformanceFailRetry},
+	{"fail marks permanent errors dead", conformanceFailPermanent},
+	{"fail marks the last attempt dead", conformanceFailLastAttempt},
+	{"release keeps the attempt", conformanceRelease},
+	{"progress and result are stored", conformanceProgress},
+	{"cancel stops pending and flags running jobs", conformanceCancel},
+	{"fail and release honour a cancel request", conformanceCancelWins},
+	{"unique key collapses active jobs", conformanceUniqueKey},
+	{"idempotency key collapses all jobs", conformanceIdempotencyKey},
+	{"marked completion outlives a failed run", conformanceMarkCompleted},
+	{"dependencies wait for parents", conformanceDependencies},
+	{"dead parents kill dependents", conformanceDependencyFailure},
+	{"enqueue under a failed parent is dead", conformanceFailedParent},
+}
+
+func TestMemoryQueueConformance(t *testing.T) {
+	runConformance(t, func(queue string) Queue { return newMemoryQueue(queue) })
+}
+
+// TestPostgresQueueConformance runs against the database in TEST_DATABASE_DSN,
+// migrated up first. Regular workers must not run against it, they would
+// claim the jobs of the cases too.
+func TestPostgresQueueConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := migrateUp(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	queues := runConformance(t, func(queue string) Queue { return newPostgresQueue(db, queue) })
+	t.Cleanup(func() {
+		db.Where("idempotency_key IN ?", idempotencyKeys(queues)).Delete(&JobCompletion{})
+		db.Where("queue IN ?", queues).Delete(&Worker{})
+	})
+}
+
+// runConformance runs every conformance case as a subtest against a queue from
+// newQueue, which must only claim jobs of the queue it is given. It returns
+// the queue names it used.
+func runConformance(t *testing.T, newQueue func(queue string) Queue) []string {
+	prefix := fmt.Sprintf("conformance-%d", time.Now().UnixNano())
+
+	var queues []string
+	for i, c := range conformanceCases {
+		queue := fmt.Sprintf("%s-%d", prefix, i)
+		queues = append(queues, queue)
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(context.Background(), newQueue(queue), queue); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	return queues
+}
+
+func idempotencyKeys(queues []string) []string {
+	keys := make([]string, len(queues))
+	for i, queue := range queues {
+		keys[i] = queue + "-idempotent"
+	}
+	return keys
+}
+
+func conformanceClaim(ctx context.Context, q Queue, queue string) error {
+	job, err := q.Enqueue(ctx, "conformance", map[string]int{"n": 1}, EnqueueOptions{Queue: queue})
+	if err != nil {
+		return err
+	}
+	if job.ID == 0 || job.Status != StatusPending {
+		return fmt.Errorf("enqueued job has ID %d and status %q", job.ID, job.Status)
+	}
+
+	claimed, err := q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 1 || claimed[0].ID != job.ID {
+		return fmt.Errorf("claimed %v, want job %d", claimedIDs(claimed), job.ID)
+	}
+
+	got, err := q.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if got.Status != StatusRunning || got.Attempts != 1 || deref(got.LockedBy) != "w1" || got.LeaseExpiresAt == nil {
+		return fmt.Errorf("claimed job is %s with %d attempt(s), locked by %s", got.Status, got.Attempts, deref(got.LockedBy))
+	}
+
+	if _, err := q.Get(ctx, job.ID+1_000_000); !errors.Is(err, ErrWorkerNotFound) {
+		return fmt.Errorf("get of a missing job returned %v, want ErrWorkerNotFound", err)
+	}
+	return nil
+}
+
+func conformanceExclusiveClaim(ctx context.Context, q Queue, queue string) error {
+	for range 3 {
+		if _, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue}); err != nil {
+			return err
+		}
+	}
+
+	first, err := q.Claim(ctx, "w1", 2)
+	if err != nil {
+		return err
+	}
+	second, err := q.Claim(ctx, "w2", 2)
+	if err != nil {
+		return err
+	}
+	if len(first) != 2 || len(second) != 1 {
+		return fmt.Errorf("claimed %d then %d job(s), want 2 then 1", len(first), len(second))
+	}
+	for _, job := range second {
+		if job.ID == first[0].ID || job.ID == first[1].ID {
+			return fmt.Errorf("job %d claimed by both workers", job.ID)
+		}
+	}
+
+	third, err := q.Claim(ctx, "w3", 2)
+	if err != nil {
+		return err
+	}
+	if len(third) != 0 {
+		return fmt.Errorf("claimed running jobs %v", claimedIDs(third))
+	}
+	return nil
+}
+
+func conformanceOrder(ctx context.Context, q Queue, queue string) error {
+	now := time.Now()
+	low, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, RunAt: now.Add(-2 * time.Second)})
+	if err != nil {
+		return err
+	}
+	later, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Priority: 10, RunAt: now.Add(-time.Second)})
+	if err != nil {
+		return err
+	}
+	earlier, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Priority: 10, RunAt: now.Add(-2 * time.Second)})
+	if err != nil {
+		return err
+	}
+
+	var order []uint
+	for range 3 {
+		claimed, err := q.Claim(ctx, "w1", 1)
+		if err != nil {
+			return err
+		}
+		order = append(order, claimedIDs(claimed)...)
+	}
+	want := []uint{earlier.ID, later.ID, low.ID}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		return fmt.Errorf("claimed in order %v, want %v", order, want)
+	}
+	return nil
+}
+
+func conformanceRunAt(ctx context.Context, q Queue, queue string) error {
+	if _, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Delay: time.Hour}); err != nil {
+		return err
+	}
+
+	claimed, err := q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 0 {
+		return fmt.Errorf("claimed jobs %v due in an hour", claimedIDs(claimed))
+	}
+	return nil
+}
+
+func conformanceTenants(ctx context.Context, q Queue, queue string) error {
+	if getEnv("FAIR_CLAIM", fairWeighted) == fairOff {
+		return nil
+	}
+
+	busy, quiet := queue+"-busy", queue+"-quiet"
+	for range 5 {
+		if _, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Tenant: busy, Priority: 10}); err != nil {
+			return err
+		}
+	}
+	job, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Tenant: quiet})
+	if err != nil {
+		return err
+	}
+	if job.TenantID != quiet {
+		return fmt.Errorf("enqueued job has tenant %q, want %q", job.TenantID, quiet)
+	}
+
+	// The busy tenant has more jobs and a higher priority, the quiet one
+	// still gets one of the first two.
+	claimed, err := q.Claim(ctx, "w1", 2)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(claimed, func(w Worker) bool { return w.ID == job.ID }) {
+		return fmt.Errorf("claimed %v, want the job %d of the quiet tenant among them", claimedIDs(claimed), job.ID)
+	}
+	return nil
+}
+
+func conformanceTenantsBatch(ctx context.Context, q Queue, queue string) error {
+	var enqueued []uint
+	for _, tenant := range []string{queue + "-a", queue + "-b"} {
+		for range 3 {
+			job, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Tenant: tenant})
+			if err != nil {
+				return err
+			}
+			enqueued = append(enqueued, job.ID)
+		}
+	}
+
+	// More jobs than tenants, so every tenant gets several turns.
+	claimed, err := q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	ids := claimedIDs(claimed)
+	slices.Sort(ids)
+	if !slices.Equal(ids, enqueued) {
+		return fmt.Errorf("claimed %v, want each of %v once", claimedIDs(claimed), enqueued)
+	}
+	return nil
+}
+
+func conformanceComplete(ctx context.Context, q Queue, queue string) error {
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Complete(ctx, "w2", job); !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("complete by another worker returned %v, want errLeaseLost", err)
+	}
+	if err := q.Complete(ctx, "w1", job); err != nil {
+		return err
+	}
+	if err := q.Complete(ctx, "w1", job); !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("second complete returned %v, want errLeaseLost", err)
+	}
+
+	got, err := q.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if got.Status != StatusFinished || got.LockedBy != nil {
+		return fmt.Errorf("completed job is %s, locked by %s", got.Status, deref(got.LockedBy))
+	}
+	return nil
+}
+
+func conformanceFailRetry(ctx context.Context, q Queue, queue string) error {
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 3})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Fail(ctx, "w1", job, errors.New("boom")); err != nil {
+		return err
+	}
+	got, err := q.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if got.Status != StatusPending || deref(got.LastError) != "boom" || !got.RunAt.After(time.Now()) {
+		return fmt.Errorf("failed job is %s at %s with error %s", got.Status, got.RunAt.Format(time.RFC3339), deref(got.LastError))
+	}
+
+	claimed, err := q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 0 {
+		return fmt.Errorf("claimed job %v before its backoff", claimedIDs(claimed))
+	}
+	return nil
+}
+
+func conformanceFailPermanent(ctx context.Context, q Queue, queue string) error {
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 3})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Fail(ctx, "w1", job, Permanent(errors.New("bad payload"))); err != nil {
+		return err
+	}
+	return expectStatus(ctx, q, job.ID, StatusDead)
+}
+
+func conformanceFailLastAttempt(ctx context.Context, q Queue, queue string) error {
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 1})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Fail(ctx, "w2", job, errors.New("boom")); !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("fail by another worker returned %v, want errLeaseLost", err)
+	}
+	if err := q.Fail(ctx, "w1", job, errors.New("boom")); err != nil {
+		return err
+	}
+	return expectStatus(ctx, q, job.ID, StatusDead)
+}
+
+func conformanceRelease(ctx context.Context, q Queue, queue string) error {
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Release(ctx, "w1", job); err != nil {
+		return err
+	}
+	got, err := q.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if got.Status != StatusPending || got.Attempts != 0 || got.LockedBy != nil {
+		return fmt.Errorf("released job is %s with %d attempt(s), locked by %s", got.Status, got.Attempts, deref(got.LockedBy))
+	}
+
+	claimed, err := q.Claim(ctx, "w2", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 1 || claimed[0].ID != job.ID {
+		return fmt.Errorf("claimed %v after release, want job %d", claimedIDs(claimed), job.ID)
+	}
+	return nil
+}
+
+func conformanceProgress(ctx context.Context, q Queue, queue string) error {
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 2})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Progress(ctx, "w2", job.ID, 50, "halfway"); !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("progress by another worker returned %v, want errLeaseLost", err)
+	}
+	if err := q.Progress(ctx, "w1", job.ID, 50, "halfway"); err != nil {
+		return err
+	}
+	got, err := q.Get(ctx, job.ID)
+	if err != nil {
+		return err
+	}
+	if got.Progress != 50 || deref(got.ProgressMessage) != "halfway" {
+		return fmt.Errorf("progress is %d%% %s, want 50%% halfway", got.Progress, deref(got.ProgressMessage))
+	}
+
+	// A failed attempt keeps its progress and result, the next claim resets them.
+	job.Result = json.RawMessage(`{"step":"upload"}`)
+	if err := q.Fail(ctx, "w1", job, errors.New("boom")); err != nil {
+		return err
+	}
+	if got, err = q.Get(ctx, job.ID); err != nil {
+		return err
+	}
+	if got.Progress != 50 || !jsonEqual(got.Result, job.Result) {
+		return fmt.Errorf("failed job has progress %d%% and result %s", got.Progress, got.Result)
+	}
+
+	if _, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue}); err != nil {
+		return err
+	}
+	next, err := q.Claim(ctx, "w1", 1)
+	if err != nil {
+		return err
+	}
+	if len(next) != 1 || next[0].Progress != 0 || next[0].Result != nil {
+		return fmt.Errorf("claimed %v with leftover progress or result", claimedIDs(next))
+	}
+
+	next[0].Result = json.RawMessage(`{"rows":3}`)
+	if err := q.Complete(ctx, "w1", &next[0]); err != nil {
+		return err
+	}
+	if got, err = q.Get(ctx, next[0].ID); err != nil {
+		return err
+	}
+	if got.Progress != 100 || !jsonEqual(got.Result, next[0].Result) {
+		return fmt.Errorf("completed job has progress %d%% and result %s", got.Progress, got.Result)
+	}
+	return nil
+}
+
+func conformanceCancel(ctx context.Context, q Queue, queue string) error {
+	pending, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Delay: time.Hour})
+	if err != nil {
+		return err
+	}
+	if err := q.RequestCancel(ctx, pending.ID); err != nil {
+		return err
+	}
+	if err := expectStatus(ctx, q, pending.ID, StatusCancelled); err != nil {
+		return err
+	}
+	if err := q.RequestCancel(ctx, pending.ID); !errors.Is(err, ErrInvalidStatus) {
+		return fmt.Errorf("cancel of a cancelled job returned %v, want ErrInvalidStatus", err)
+	}
+	if err := q.RequestCancel(ctx, pending.ID+1_000_000); !errors.Is(err, ErrWorkerNotFound) {
+		return fmt.Errorf("cancel of a missing job returned %v, want ErrWorkerNotFound", err)
+	}
+
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{})
+	if err != nil {
+		return err
+	}
+	if err := q.RequestCancel(ctx, job.ID); err != nil {
+		return err
+	}
+	if err := expectStatus(ctx, q, job.ID, StatusCancelRequested); err != nil {
+		return err
+	}
+
+	// The worker keeps the job until its handler stopped.
+	if err := q.Progress(ctx, "w1", job.ID, 80, "stopping"); err != nil {
+		return err
+	}
+	if err := q.Cancel(ctx, "w2", job); !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("cancel by another worker returned %v, want errLeaseLost", err)
+	}
+	if err := q.Cancel(ctx, "w1", job); err != nil {
+		return err
+	}
+	return expectStatus(ctx, q, job.ID, StatusCancelled)
+}
+
+func conformanceCancelWins(ctx context.Context, q Queue, queue string) error {
+	failed, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 3})
+	if err != nil {
+		return err
+	}
+	if err := q.RequestCancel(ctx, failed.ID); err != nil {
+		return err
+	}
+	if err := q.Fail(ctx, "w1", failed, errors.New("boom")); err != nil {
+		return err
+	}
+	if err := expectStatus(ctx, q, failed.ID, StatusCancelled); err != nil {
+		return err
+	}
+
+	released, err := claimOne(ctx, q, queue, EnqueueOptions{})
+	if err != nil {
+		return err
+	}
+	child, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, DependsOn: []uint{released.ID}})
+	if err != nil {
+		return err
+	}
+	if err := q.RequestCancel(ctx, released.ID); err != nil {
+		return err
+	}
+	if err := q.Release(ctx, "w1", released); err != nil {
+		return err
+	}
+	if err := expectStatus(ctx, q, released.ID, StatusCancelled); err != nil {
+		return err
+	}
+	return expectStatus(ctx, q, child.ID, StatusDead)
+}
+
+func conformanceUniqueKey(ctx context.Context, q Queue, queue string) error {
+	opts := EnqueueOptions{Queue: queue, UniqueKey: queue + "-unique"}
+	first, err := q.Enqueue(ctx, "conformance", nil, opts)
+	if err != nil {
+		return err
+	}
+	second, err := q.Enqueue(ctx, "conformance", nil, opts)
+	if err != nil {
+		return err
+	}
+	if second.ID != first.ID {
+		return fmt.Errorf("pending duplicate got job %d, want %d", second.ID, first.ID)
+	}
+	keyed, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, UniqueKey: opts.UniqueKey, IdempotencyKey: queue + "-idempotent"})
+	if err != nil {
+		return err
+	}
+	if keyed.ID != first.ID {
+		return fmt.Errorf("duplicate with a new idempotency key got job %d, want %d", keyed.ID, first.ID)
+	}
+
+	claimed, err := q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 1 {
+		return fmt.Errorf("claimed %d job(s), want 1", len(claimed))
+	}
+	if err := q.Complete(ctx, "w1", &claimed[0]); err != nil {
+		return err
+	}
+
+	third, err := q.Enqueue(ctx, "conformance", nil, opts)
+	if err != nil {
+		return err
+	}
+	if third.ID == first.ID {
+		return fmt.Errorf("key of finished job %d still collapses enqueues", first.ID)
+	}
+
+	// A job whose handler is being stopped still holds its key.
+	claimed, err = q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 1 || claimed[0].ID != third.ID {
+		return fmt.Errorf("claimed %v, want job %d", claimedIDs(claimed), third.ID)
+	}
+	if err := q.RequestCancel(ctx, third.ID); err != nil {
+		return err
+	}
+	fourth, err := q.Enqueue(ctx, "conformance", nil, opts)
+	if err != nil {
+		return err
+	}
+	if fourth.ID != third.ID {
+		return fmt.Errorf("duplicate of a job with a cancel requested got job %d, want %d", fourth.ID, third.ID)
+	}
+	return nil
+}
+
+func conformanceIdempotencyKey(ctx context.Context, q Queue, queue string) error {
+	key := queue + "-idempotent"
+	opts := EnqueueOptions{Queue: queue, IdempotencyKey: key}
+	job, err := claimOne(ctx, q, queue, opts)
+	if err != nil {
+		return err
+	}
+
+	if completed, err := q.Completed(ctx, key); err != nil || completed {
+		return fmt.Errorf("running job reported completed %t: %v", completed, err)
+	}
+	if err := q.Complete(ctx, "w1", job); err != nil {
+		return err
+	}
+	if completed, err := q.Completed(ctx, key); err != nil || !completed {
+		return fmt.Errorf("finished job reported completed %t: %v", completed, err)
+	}
+
+	again, err := q.Enqueue(ctx, "conformance", nil, opts)
+	if err != nil {
+		return err
+	}
+	if again.ID != job.ID || again.Status != StatusFinished {
+		return fmt.Errorf("enqueue after completion got job %d in %s, want finished job %d", again.ID, again.Status, job.ID)
+	}
+	return nil
+}
+
+func conformanceMarkCompleted(ctx context.Context, q Queue, queue string) error {
+	key := queue + "-idempotent"
+	job, err := claimOne(ctx, q, queue, EnqueueOptions{IdempotencyKey: key})
+	if err != nil {
+		return err
+	}
+
+	for range 2 {
+		if err := q.MarkCompleted(ctx, key, job.ID); err != nil {
+			return err
+		}
+	}
+	if err := q.Fail(ctx, "w1", job, errors.New("boom after the side effects")); err != nil {
+		return err
+	}
+	if err := expectStatus(ctx, q, job.ID, StatusPending); err != nil {
+		return err
+	}
+	if completed, err := q.Completed(ctx, key); err != nil || !completed {
+		return fmt.Errorf("job marked completed before failing reported completed %t: %v", completed, err)
+	}
+	return nil
+}
+
+func conformanceDependencies(ctx context.Context, q Queue, queue string) error {
+	parent, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue})
+	if err != nil {
+		return err
+	}
+	child, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, Priority: 10, DependsOn: []uint{parent.ID}})
+	if err != nil {
+		return err
+	}
+
+	claimed, err := q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 1 || claimed[0].ID != parent.ID {
+		return fmt.Errorf("claimed %v, want only parent %d", claimedIDs(claimed), parent.ID)
+	}
+	if err := q.Complete(ctx, "w1", &claimed[0]); err != nil {
+		return err
+	}
+
+	claimed, err = q.Claim(ctx, "w1", 10)
+	if err != nil {
+		return err
+	}
+	if len(claimed) != 1 || claimed[0].ID != child.ID {
+		return fmt.Errorf("claimed %v after the parent finished, want child %d", claimedIDs(claimed), child.ID)
+	}
+
+	if _, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, DependsOn: []uint{child.ID + 1_000_000}}); err == nil {
+		return errors.New("enqueue with a missing parent succeeded")
+	}
+	return nil
+}
+
+func conformanceDependencyFailure(ctx context.Context, q Queue, queue string) error {
+	parent, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 1})
+	if err != nil {
+		return err
+	}
+	child, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, DependsOn: []uint{parent.ID}})
+	if err != nil {
+		return err
+	}
+	grandchild, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, DependsOn: []uint{child.ID}})
+	if err != nil {
+		return err
+	}
+
+	if err := q.Fail(ctx, "w1", parent, errors.New("boom")); err != nil {
+		return err
+	}
+	if err := expectStatus(ctx, q, child.ID, StatusDead); err != nil {
+		return err
+	}
+	return expectStatus(ctx, q, grandchild.ID, StatusDead)
+}
+
+func conformanceFailedParent(ctx context.Context, q Queue, queue string) error {
+	dead, err := claimOne(ctx, q, queue, EnqueueOptions{MaxAttempts: 1})
+	if err != nil {
+		return err
+	}
+	if err := q.Fail(ctx, "w1", dead, errors.New("boom")); err != nil {
+		return err
+	}
+	cancelled, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue})
+	if err != nil {
+		return err
+	}
+	if err := q.RequestCancel(ctx, cancelled.ID); err != nil {
+		return err
+	}
+
+	for _, parent := range []*Worker{dead, cancelled} {
+		child, err := q.Enqueue(ctx, "conformance", nil, EnqueueOptions{Queue: queue, DependsOn: []uint{parent.ID}})
+		if err != nil {
+			return err
+		}
+		if child.Status != StatusDead {
+			return fmt.Errorf("child of job %d enqueued %s, want dead", parent.ID, child.Status)
+		}
+		if err := expectStatus(ctx, q, child.ID, StatusDead); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimOne enqueues a job on queue and claims it as w1.
+func claimOne(ctx context.Context, q Queue, queue string, opts EnqueueOptions) (*Worker, error) {
+	opts.Queue = queue
+	job, err := q.Enqueue(ctx, "conformance", nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := q.Claim(ctx, "w1", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(claimed) != 1 || claimed[0].ID != job.ID {
+		return nil, fmt.Errorf("claimed %v, want job %d", claimedIDs(claimed), job.ID)
+	}
+	return &claimed[0], nil
+}
+
+func expectStatus(ctx context.Context, q Queue, id uint, status string) error {
+	got, err := q.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if got.Status != status {
+		return fmt.Errorf("job %d is %s, want %s", id, got.Status, status)
+	}
+	return nil
+}
+
+// jsonEqual compares JSON documents, jsonb does not keep the formatting.
+func jsonEqual(a, b json.RawMessage) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func claimedIDs(workers []Worker) []uint {
+	ids := make([]uint, len(workers))
+	for i, worker := range workers {
+		ids[i] = worker.ID
+	}
+	return ids
+}
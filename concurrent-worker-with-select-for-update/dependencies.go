@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// JobDependency makes JobID wait until ParentID is finished.
+type JobDependency struct {
+	JobID    uint `gorm:"primaryKey;autoIncrement:false"`
+	ParentID uint `gorm:"primaryKey;autoIncrement:false"`
+}
+
+// parentsFinished is the claim condition for jobs with dependencies. It is
+// written against the workers table of the claim query.
+const parentsFinished = `NOT EXISTS (
+	SELECT 1 FROM job_dependencies d JOIN workers p ON p.id = d.parent_id
+	WHERE d.job_id = workers.id AND p.status <> 'finished')`
+
+// addDependencies makes job wait for every parent.
+func addDependencies(tx *gorm.DB, jobID uint, parentIDs []uint) error {
+	if len(parentIDs) == 0 {
+		return nil
+	}
+
+	dependencies := make([]JobDependency, len(parentIDs))
+	for i, parentID := range parentIDs {
+		dependencies[i] = JobDependency{JobID: jobID, ParentID: parentID}
+	}
+	return tx.Create(&dependencies).Error
+}
+
+// failIfParentFailed marks a job just enqueued dead right away when a parent
+// is already dead or cancelled, instead of waiting for propagateFailures.
+func failIfParentFailed(tx *gorm.DB, worker *Worker) error {
+	var lastErrors []string
+	if err := tx.Raw(`UPDATE workers SET
+			status = ?,
+			last_error = 'parent job ' || p.id || ' is ' || p.status,
+			updated_at = now()
+		FROM job_dependencies d JOIN workers p ON p.id = d.parent_id
+		WHERE d.job_id = workers.id AND workers.id = ? AND p.status IN ?
+		RETURNING workers.last_error`,
+		StatusDead, worker.ID, []string{StatusDead, StatusCancelled}).
+		Scan(&lastErrors).Error; err != nil {
+		return err
+	}
+	if len(lastErrors) > 0 {
+		worker.Status = StatusDead
+		worker.LastError = &lastErrors[0]
+	}
+	return nil
+}
+
+// notifyDependents wakes idle workers when a finished job unblocks children.
+func notifyDependents(tx *gorm.DB, parentID uint) error {
+	return tx.Exec("SELECT pg_notify(?, '') WHERE EXISTS (SELECT 1 FROM job_dependencies WHERE parent_id = ?)",
+		jobsChannel, parentID).Error
+}
+
+// propagateFailures marks pending jobs dead when a parent is dead or
+// cancelled, since they can never run. It repeats until the whole chain of
+// descendants is marked.
+func propagateFailures(ctx context.Context, db *gorm.DB) (int64, error) {
+	var total int64
+	for {
+		res := db.WithContext(ctx).Exec(`UPDATE workers SET
+				status = ?,
+				last_error = 'parent job ' || p.id || ' is ' || p.status,
+				updated_at = now()
+			FROM job_dependencies d JOIN workers p ON p.id = d.parent_id
+			WHERE d.job_id = workers.id AND workers.status = ? AND p.status IN ?`,
+			StatusDead, StatusPending, []string{StatusDead, StatusCancelled})
+		if res.Error != nil {
+			return total, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return total, nil
+		}
+		total += res.RowsAffected
+	}
+}
+
+// propagateFailuresAfter runs propagateFailures after a job stopped for good,
+// logging instead of failing the caller.
+func propagateFailuresAfter(ctx context.Context, db *gorm.DB, worker *Worker) {
+	failed, err := propagateFailures(ctx, db)
+	if err != nil {
+		log.Printf("Failed to propagate failure of worker ID %d: %v\n", worker.ID, err)
+		return
+	}
+	if failed > 0 {
+		log.Printf("Marked %d dependent job(s) of worker ID %d dead\n", failed, worker.ID)
+	}
+}
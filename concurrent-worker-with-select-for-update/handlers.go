@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Handler processes the payload of a single job. Returning an error fails the
+// attempt, wrap it with Permanent to skip the remaining retries.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+var handlers = map[string]Handler{}
+
+// RegisterHandler makes handler responsible for every job of jobType.
+func RegisterHandler(jobType string, handler Handler) {
+	if _, exist := handlers[jobType]; exist {
+		panic(fmt.Sprintf("handler for job type %q already registered", jobType))
+	}
+	handlers[jobType] = handler
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying, the job goes straight to dead.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func isPermanent(err error) bool {
+	var perr *permanentError
+	return errors.As(err, &perr)
+}
+
+type jobContextKey struct{}
+
+type jobContext struct {
+	queue  Queue
+	worker *Worker
+}
+
+func withJob(ctx context.Context, q Queue, worker *Worker) context.Context {
+	return context.WithValue(ctx, jobContextKey{}, &jobContext{queue: q, worker: worker})
+}
+
+// JobFromContext returns the row being processed by the current handler.
+func JobFromContext(ctx context.Context) (*Worker, bool) {
+	job, ok := ctx.Value(jobContextKey{}).(*jobContext)
+	if !ok {
+		return nil, false
+	}
+	return job.worker, true
+}
+
+// AlreadyCompleted reports whether a job with the idempotency key of the
+// current job has already finished or called MarkCompleted, so the handler
+// can skip side effects that ran before. It is always false for jobs without
+// an idempotency key.
+func AlreadyCompleted(ctx context.Context) (bool, error) {
+	job, ok := ctx.Value(jobContextKey{}).(*jobContext)
+	if !ok {
+		return false, errors.New("not called from a job handler")
+	}
+	if job.worker.IdempotencyKey == nil {
+		return false, nil
+	}
+
+	return job.queue.Completed(ctx, *job.worker.IdempotencyKey)
+}
+
+// MarkCompleted records the idempotency key of the current job as completed
+// right away, for a handler to call once its side effects are done. A run
+// that loses its lease or fails afterwards then finds AlreadyCompleted true
+// when the job runs again. It does nothing for jobs without an idempotency
+// key.
+func MarkCompleted(ctx context.Context) error {
+	job, ok := ctx.Value(jobContextKey{}).(*jobContext)
+	if !ok {
+		return errors.New("not called from a job handler")
+	}
+	if job.worker.IdempotencyKey == nil {
+		return nil
+	}
+
+	return job.queue.MarkCompleted(ctx, *job.worker.IdempotencyKey, job.worker.ID)
+}
+
+// ReportProgress records how far the current job is, percent from 0 to 100
+// with an optional message, so callers polling the job can follow it.
+func ReportProgress(ctx context.Context, percent int, message string) error {
+	job, ok := ctx.Value(jobContextKey{}).(*jobContext)
+	if !ok {
+		return errors.New("not called from a job handler")
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("progress %d is not between 0 and 100", percent)
+	}
+
+	if err := job.queue.Progress(ctx, *job.worker.LockedBy, job.worker.ID, percent, message); err != nil {
+		return err
+	}
+	job.worker.Progress = percent
+	return nil
+}
+
+// SetResult stores result as the JSON result of the current job. It is saved
+// with the outcome of the attempt, so a failing handler can leave structured
+// error details next to last_error.
+func SetResult(ctx context.Context, result any) error {
+	job, ok := ctx.Value(jobContextKey{}).(*jobContext)
+	if !ok {
+		return errors.New("not called from a job handler")
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	job.worker.Result = raw
+	return nil
+}
+
+func registerHandlers() {
+	RegisterHandler("simulate", simulateHandler)
+	RegisterHandler("echo", echoHandler)
+}
+
+type simulatePayload struct {
+	// Seconds to sleep, a random 2-11 seconds when zero.
+	Seconds int `json:"seconds"`
+}
+
+// simulateHandler sleeps to simulate work and fails FAIL_RATE percent of the time.
+func simulateHandler(ctx context.Context, payload json.RawMessage) error {
+	var p simulatePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	sleepDuration := time.Duration(p.Seconds) * time.Second
+	if sleepDuration == 0 {
+		// random sleep between 2-11 seconds to simulate work
+		sleepDuration = time.Duration(2+time.Now().UnixNano()%10) * time.Second
+	}
+	// Sleep in ten steps to report progress along the way
+	step := sleepDuration / 10
+	for i := 1; i <= 10; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step):
+		}
+		if err := ReportProgress(ctx, i*10, fmt.Sprintf("slept %s of %s", time.Duration(i)*step, sleepDuration)); err != nil {
+			return err
+		}
+	}
+
+	if rand.Intn(100) < getEnvInt("FAIL_RATE", 0) {
+		SetResult(ctx, map[string]any{"failed_after": sleepDuration.String()})
+		return errors.New("simulated failure")
+	}
+	return SetResult(ctx, map[string]any{"slept": sleepDuration.String()})
+}
+
+func echoHandler(ctx context.Context, payload json.RawMessage) error {
+	log.Printf("echo: %s\n", payload)
+	return nil
+}
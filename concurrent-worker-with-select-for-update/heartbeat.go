@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WorkerHeartbeat is the last sign of life of one worker loop.
+type WorkerHeartbeat struct {
+	Name         string    `gorm:"primaryKey" json:"name"`
+	Host         string    `gorm:"not null" json:"host"`
+	StartedAt    time.Time `gorm:"type:timestamptz;not null" json:"started_at"`
+	LastSeen     time.Time `gorm:"type:timestamptz;not null" json:"last_seen"`
+	CurrentJobID *uint     `json:"current_job_id,omitempty"`
+	Live         bool      `gorm:"-" json:"live"`
+}
+
+// currentJobs tracks the rows this process holds and the job each worker
+// loop is running.
+var currentJobs = &jobTracker{jobs: map[string]*trackedJob{}, held: map[uint]bool{}}
+
+type jobTracker struct {
+	mu   sync.Mutex
+	jobs map[string]*trackedJob
+	// held are the rows claimed by this process and not recorded yet,
+	// running or waiting for their turn in a batch.
+	held map[uint]bool
+}
+
+type trackedJob struct {
+	id        uint
+	cancel    context.CancelCauseFunc
+	cancelled bool
+}
+
+// start tracks job id of workerName, cancel stops its handler.
+func (t *jobTracker) start(workerName string, id uint, cancel context.CancelCauseFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs[workerName] = &trackedJob{id: id, cancel: cancel}
+}
+
+// hold records rows just claimed by this process.
+func (t *jobTracker) hold(workers []Worker) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, worker := range workers {
+		t.held[worker.ID] = true
+	}
+}
+
+// drop forgets a held row once its outcome is recorded.
+func (t *jobTracker) drop(id uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.held, id)
+}
+
+func (t *jobTracker) heldIDs() []uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Collect(maps.Keys(t.held))
+}
+
+func (t *jobTracker) done(workerName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.jobs, workerName)
+}
+
+func (t *jobTracker) current(workerName string) *uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if job, exist := t.jobs[workerName]; exist {
+		return &job.id
+	}
+	return nil
+}
+
+// cancel stops the handler of job id if it runs in this process.
+func (t *jobTracker) cancel(id uint) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for workerName, job := range t.jobs {
+		if job.id != id {
+			continue
+		}
+		if !job.cancelled {
+			log.Printf("%s : Cancel requested for worker ID %d\n", workerName, id)
+			job.cancel(errCancelRequested)
+			job.cancelled = true
+		}
+		return true
+	}
+	return false
+}
+
+// heartbeatStaleAfter is how long a worker may go without a heartbeat before
+// it is considered dead.
+func heartbeatStaleAfter() time.Duration {
+	return getEnvDuration("HEARTBEAT_STALE_AFTER", time.Minute)
+}
+
+// runHeartbeat upserts a heartbeat for every worker loop in names, renews the
+// leases they hold and stops handlers of cancelled jobs, every
+// HEARTBEAT_INTERVAL until ctx is cancelled. The cancel notification usually
+// gets there first, the heartbeat catches the ones the listener missed.
+func runHeartbeat(ctx context.Context, db *gorm.DB, names []string) {
+	host, _ := os.Hostname()
+	startedAt := time.Now()
+
+	ticker := time.NewTicker(getEnvDuration("HEARTBEAT_INTERVAL", 10*time.Second))
+	defer ticker.Stop()
+
+	for {
+		if err := beat(ctx, db, host, startedAt, names); err != nil && ctx.Err() == nil {
+			log.Println("Failed to send heartbeat:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func beat(ctx context.Context, db *gorm.DB, host string, startedAt time.Time, names []string) error {
+	now := time.Now()
+
+	heartbeats := make([]WorkerHeartbeat, len(names))
+	for i, name := range names {
+		heartbeats[i] = WorkerHeartbeat{
+			Name:         name,
+			Host:         host,
+			StartedAt:    startedAt,
+			LastSeen:     now,
+			CurrentJobID: currentJobs.current(name),
+		}
+	}
+
+	// Only rows this process claimed, a restarted process reuses the names of
+	// the one that crashed and must not keep its jobs alive.
+	held := currentJobs.heldIDs()
+
+	var cancelled []uint
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"host", "started_at", "last_seen", "current_job_id"}),
+		}).Create(&heartbeats).Error; err != nil {
+			return err
+		}
+		if len(held) == 0 {
+			return nil
+		}
+
+		// Jobs of a live worker keep their lease however long they run.
+		if err := tx.Model(&Worker{}).
+			Where("id IN ? AND status IN ? AND locked_by IN ?", held, claimedStatuses, names).
+			Update("lease_expires_at", now.Add(leaseDuration())).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Worker{}).
+			Where("id IN ? AND status = ? AND locked_by IN ?", held, StatusCancelRequested, names).
+			Pluck("id", &cancelled).Error
+	}); err != nil {
+		return err
+	}
+
+	for _, id := range cancelled {
+		currentJobs.cancel(id)
+	}
+	return nil
+}
+
+// removeHeartbeats deregisters the worker loops in names on a clean exit.
+func removeHeartbeats(ctx context.Context, db *gorm.DB, names []string) error {
+	return db.WithContext(ctx).Where("name IN ?", names).Delete(&WorkerHeartbeat{}).Error
+}
+
+// listHeartbeats returns every known worker loop, live or stale, by name.
+func listHeartbeats(ctx context.Context, db *gorm.DB) ([]WorkerHeartbeat, error) {
+	var heartbeats []WorkerHeartbeat
+	if err := db.WithContext(ctx).Order("name").Find(&heartbeats).Error; err != nil {
+		return nil, err
+	}
+
+	staleBefore := time.Now().Add(-heartbeatStaleAfter())
+	for i := range heartbeats {
+		heartbeats[i].Live = heartbeats[i].LastSeen.After(staleBefore)
+	}
+	return heartbeats, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrWorkerNotFound = errors.New("worker not found")
+	ErrInvalidStatus  = errors.New("worker is not in a valid status for this operation")
+)
+
+// EnqueueOptions tune how an enqueued job is scheduled. The zero value runs
+// the job as soon as possible with the default priority and attempts.
+type EnqueueOptions struct {
+	// Queue names the queue whose limits apply, DefaultQueue when empty.
+	Queue string
+	// Tenant owns the job, DefaultTenant when empty. Claims take turns
+	// between tenants, see FAIR_CLAIM.
+	Tenant string
+	// RunAt schedules the first run, now when zero.
+	RunAt time.Time
+	// Delay postpones the first run, added on top of RunAt.
+	Delay time.Duration
+	// Priority orders due jobs, higher runs first.
+	Priority int
+	// UniqueKey collapses enqueues while a job with the same key is still
+	// pending or running, even with a cancel requested, the existing job is
+	// returned instead.
+	UniqueKey string
+	// IdempotencyKey identifies the job forever, enqueueing the same key again
+	// returns the existing job whatever its status. Handlers can call
+	// MarkCompleted after their side effects and check AlreadyCompleted
+	// before repeating them.
+	IdempotencyKey string
+	// MaxAttempts overrides the column default when set.
+	MaxAttempts int
+	// DependsOn holds parent job IDs that must all be finished before this
+	// job can be claimed. The job is marked dead if any parent dies or is
+	// cancelled, at once when one already has.
+	DependsOn []uint
+}
+
+// Enqueue inserts a pending job of jobType. payload is stored as JSON, a
+// json.RawMessage is stored as is.
+func Enqueue(ctx context.Context, db *gorm.DB, jobType string, payload any, opts EnqueueOptions) (*Worker, error) {
+	worker, err := newWorker(jobType, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for range enqueueAttempts {
+		inserted := false
+		if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&worker)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			inserted = true
+			if err := addDependencies(tx, worker.ID, opts.DependsOn); err != nil {
+				return err
+			}
+			return failIfParentFailed(tx, &worker)
+		}); err != nil {
+			return nil, err
+		}
+		if inserted {
+			worker.DependsOn = opts.DependsOn
+			return &worker, nil
+		}
+
+		existing, err := findEnqueued(ctx, db, opts)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+		// The active job holding the unique key ended in between, try again.
+	}
+	return nil, fmt.Errorf("enqueue kept conflicting after %d attempts", enqueueAttempts)
+}
+
+// enqueueAttempts bounds the inserts of Enqueue when the job it conflicts with
+// is gone by the time it is looked up.
+const enqueueAttempts = 3
+
+// findEnqueued returns the job an Enqueue with opts lost against: the job
+// with the same idempotency key, or the active job with the same unique key.
+// It returns nil when there is none anymore.
+func findEnqueued(ctx context.Context, db *gorm.DB, opts EnqueueOptions) (*Worker, error) {
+	var conds []string
+	var args []any
+	if opts.IdempotencyKey != "" {
+		conds = append(conds, "idempotency_key = ?")
+		args = append(args, opts.IdempotencyKey)
+	}
+	if opts.UniqueKey != "" {
+		conds = append(conds, "(unique_key = ? AND status IN ?)")
+		args = append(args, opts.UniqueKey, activeStatuses)
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+
+	var existing []Worker
+	if err := db.WithContext(ctx).
+		Where(strings.Join(conds, " OR "), args...).
+		Order("id").
+		Limit(1).
+		Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+	return &existing[0], nil
+}
+
+// newWorker builds the pending row Enqueue inserts, shared by every Queue
+// implementation.
+func newWorker(jobType string, payload any, opts EnqueueOptions) (Worker, error) {
+	if jobType == "" {
+		return Worker{}, errors.New("job type is required")
+	}
+
+	raw, err := marshalPayload(payload)
+	if err != nil {
+		return Worker{}, err
+	}
+
+	runAt := opts.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	queue := opts.Queue
+	if queue == "" {
+		queue = DefaultQueue
+	}
+
+	tenant := opts.Tenant
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+
+	worker := Worker{
+		Status:      StatusPending,
+		JobType:     jobType,
+		Queue:       queue,
+		TenantID:    tenant,
+		Payload:     raw,
+		Priority:    opts.Priority,
+		MaxAttempts: opts.MaxAttempts,
+		RunAt:       runAt.Add(opts.Delay),
+	}
+	if worker.MaxAttempts <= 0 {
+		worker.MaxAttempts = 5
+	}
+	if opts.UniqueKey != "" {
+		worker.UniqueKey = &opts.UniqueKey
+	}
+	if opts.IdempotencyKey != "" {
+		worker.IdempotencyKey = &opts.IdempotencyKey
+	}
+	return worker, nil
+}
+
+// marshalPayload encodes payload as JSON, nil becomes an empty object.
+func marshalPayload(payload any) (json.RawMessage, error) {
+	if payload == nil {
+		return json.RawMessage("{}"), nil
+	}
+	return json.Marshal(payload)
+}
+
+// getWorker returns row id.
+func getWorker(ctx context.Context, db *gorm.DB, id uint) (*Worker, error) {
+	var worker Worker
+	if err := db.WithContext(ctx).Limit(1).Find(&worker, id).Error; err != nil {
+		return nil, err
+	}
+	if worker.ID == 0 {
+		return nil, ErrWorkerNotFound
+	}
+	return &worker, nil
+}
+
+// reportProgress stores the progress of a row running under workerName.
+func reportProgress(ctx context.Context, db *gorm.DB, workerName string, id uint, percent int, message string) error {
+	var progressMessage *string
+	if message != "" {
+		progressMessage = &message
+	}
+
+	res := db.WithContext(ctx).Model(&Worker{}).Where("id = ? AND status IN ? AND locked_by = ?", id, claimedStatuses, workerName).Updates(map[string]any{
+		"progress":         percent,
+		"progress_message": progressMessage,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// listWorkers returns up to limit rows, newest first, optionally by status.
+func listWorkers(ctx context.Context, db *gorm.DB, status string, limit int) ([]Worker, error) {
+	query := db.WithContext(ctx).Order("id DESC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var workers []Worker
+	if err := query.Find(&workers).Error; err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+// retryWorker gives a dead row a fresh set of attempts and makes it due now.
+func retryWorker(ctx context.Context, db *gorm.DB, id uint) error {
+	return updateWorkerStatus(ctx, db, id, StatusDead, map[string]any{
+		"status":   StatusPending,
+		"attempts": 0,
+		"run_at":   time.Now(),
+	})
+}
+
+// cancelWorker stops a pending row from ever being claimed, along with the
+// jobs depending on it. A running row is marked cancel_requested and its
+// worker is notified, it is cancelled once the handler has stopped.
+func cancelWorker(ctx context.Context, db *gorm.DB, id uint) error {
+	err := updateWorkerStatus(ctx, db, id, StatusPending, map[string]any{
+		"status": StatusCancelled,
+	})
+	if err == nil {
+		propagateFailuresAfter(ctx, db, &Worker{ID: id})
+		return nil
+	}
+	if !errors.Is(err, ErrInvalidStatus) {
+		return err
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := updateWorkerStatus(ctx, tx, id, StatusRunning, map[string]any{
+			"status": StatusCancelRequested,
+		}); err != nil {
+			return err
+		}
+		return tx.Exec("SELECT pg_notify(?, ?)", cancelChannel, strconv.FormatUint(uint64(id), 10)).Error
+	})
+}
+
+// updateWorkerStatus applies updates to row id only while it is in status
+// from, telling apart a missing row from one in another status.
+func updateWorkerStatus(ctx context.Context, db *gorm.DB, id uint, from string, updates map[string]any) error {
+	res := db.WithContext(ctx).Model(&Worker{}).Where("id = ? AND status = ?", id, from).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+
+	var count int64
+	if err := db.WithContext(ctx).Model(&Worker{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrWorkerNotFound
+	}
+	return ErrInvalidStatus
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// jobsChannel is notified by the workers insert trigger, see migrations.
+const jobsChannel = "workers_pending"
+
+// cancelChannel is notified with the ID of a running job to cancel.
+const cancelChannel = "workers_cancel"
+
+// waker lets any number of idle worker loops wait for the next notification.
+type waker struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newWaker() *waker {
+	return &waker{ch: make(chan struct{})}
+}
+
+// C returns a channel that is closed on the next Broadcast. Grab it before
+// looking for work so a notification arriving in between is not missed.
+func (w *waker) C() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ch
+}
+
+// Broadcast wakes every loop currently waiting on C.
+func (w *waker) Broadcast() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.ch)
+	w.ch = make(chan struct{})
+}
+
+// listenForJobs keeps a dedicated connection LISTENing on jobsChannel and
+// wakes the idle loops whenever new rows are inserted. It also LISTENs on
+// cancelChannel to stop handlers of cancelled jobs. It reconnects on error.
+func listenForJobs(ctx context.Context, dsn string, wake *waker) {
+	for {
+		if err := listen(ctx, dsn, wake); err != nil && ctx.Err() == nil {
+			log.Println("Listener failed, reconnecting:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func listen(ctx context.Context, dsn string, wake *waker) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	for _, channel := range []string{jobsChannel, cancelChannel} {
+		if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+			return err
+		}
+	}
+	log.Printf("Listening for new jobs on %s and cancellations on %s\n", jobsChannel, cancelChannel)
+
+	// Work may have been inserted while we were not listening.
+	wake.Broadcast()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		if notification.Channel == cancelChannel {
+			if id, err := strconv.ParseUint(notification.Payload, 10, 64); err == nil {
+				currentJobs.cancel(uint(id))
+			}
+			continue
+		}
+		wake.Broadcast()
+	}
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -14,101 +20,478 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+const (
+	StatusPending         = "pending"
+	StatusRunning         = "running"
+	StatusCancelRequested = "cancel_requested"
+	StatusFinished        = "finished"
+	StatusDead            = "dead"
+	StatusCancelled       = "cancelled"
+)
+
+// claimedStatuses are the statuses of a row held by a worker, a row whose
+// cancellation was requested stays with its worker until the handler stops.
+var claimedStatuses = []string{StatusRunning, StatusCancelRequested}
+
+// activeStatuses are the statuses of a row that still holds its unique key.
+var activeStatuses = []string{StatusPending, StatusRunning, StatusCancelRequested}
+
 type Worker struct {
-	ID     uint   `gorm:"primaryKey"`
-	Status string `gorm:"not null;default:pending"`
+	ID              uint            `gorm:"primaryKey" json:"id"`
+	Status          string          `gorm:"not null;default:pending" json:"status"`
+	JobType         string          `gorm:"not null;default:simulate" json:"job_type"`
+	Queue           string          `gorm:"not null;default:default" json:"queue"`
+	TenantID        string          `gorm:"not null;default:default" json:"tenant_id"`
+	Payload         json.RawMessage `gorm:"type:jsonb;not null;default:'{}'" json:"payload"`
+	Priority        int             `gorm:"not null;default:0" json:"priority"`
+	UniqueKey       *string         `json:"unique_key,omitempty"`
+	IdempotencyKey  *string         `json:"idempotency_key,omitempty"`
+	Attempts        int             `gorm:"not null;default:0" json:"attempts"`
+	MaxAttempts     int             `gorm:"not null;default:5" json:"max_attempts"`
+	RunAt           time.Time       `gorm:"type:timestamptz;not null;default:now()" json:"run_at"`
+	LockedBy        *string         `json:"locked_by,omitempty"`
+	LeaseExpiresAt  *time.Time      `gorm:"type:timestamptz" json:"lease_expires_at,omitempty"`
+	LastError       *string         `json:"last_error,omitempty"`
+	Progress        int             `gorm:"not null;default:0" json:"progress"`
+	ProgressMessage *string         `json:"progress_message,omitempty"`
+	Result          json.RawMessage `gorm:"type:jsonb" json:"result,omitempty"`
+	DependsOn       []uint          `gorm:"-" json:"depends_on,omitempty"`
+	CreatedAt       time.Time       `gorm:"type:timestamptz;not null;default:now()" json:"created_at"`
+	UpdatedAt       time.Time       `gorm:"type:timestamptz;not null;default:now()" json:"updated_at"`
+}
+
+// errLeaseLost is returned when a running row is no longer ours to update.
+var errLeaseLost = errors.New("lease lost")
+
+// errCancelRequested is the cause of a handler context cancelled because the
+// job was cancelled.
+var errCancelRequested = errors.New("cancel requested")
+
+// JobCompletion records that the job with IdempotencyKey finished, written
+// in the same transaction as the status flip, or earlier by MarkCompleted.
+type JobCompletion struct {
+	IdempotencyKey string    `gorm:"primaryKey"`
+	WorkerID       uint      `gorm:"not null"`
+	CompletedAt    time.Time `gorm:"type:timestamptz;not null"`
 }
 
 type WorkerLog struct {
 	ID         uint       `gorm:"primaryKey"`
 	WorkerID   uint       `gorm:"not null"`
+	Status     string     `gorm:"not null;default:finished"`
 	FinishedAt *time.Time `gorm:"type:timestamptz"`
 	WorkerName string     `gorm:"not null"`
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
+	cfg, err := loadDatabaseConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Database connection
-	db, err := setupDatabase()
+	db, err := setupDatabase(cfg)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	ctx := context.Background()
+	if pending, err := pendingMigrations(context.Background(), db); err != nil {
+		log.Println("Failed to check schema migrations:", err)
+	} else if len(pending) > 0 {
+		log.Printf("Warning: %d schema migration(s) pending, run `worker migrate up`\n", len(pending))
+	}
+
+	registerHandlers()
+	if err := registerRetention(context.Background(), db); err != nil {
+		log.Println("Failed to register the retention schedule:", err)
+	}
+	registerMetrics(db)
 
-	shutdown := make(chan int)
-	go run(ctx, shutdown, db)
+	// ctx stops claiming new work on SIGINT/SIGTERM, jobCtx is what the
+	// handlers see and is only cancelled once the drain timeout is up.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
 
-	log.Println("Worker is running...")
+	wake := newWaker()
+	go listenForJobs(ctx, cfg.ConnString(), wake)
+	go runReaper(ctx, db)
+	go runScheduler(ctx, db)
+	go runAdminServer(ctx, db)
+
+	// The heartbeat keeps leases alive while jobs drain and is stopped
+	// before its rows are removed, so a last tick cannot bring them back.
+	names := workerNames()
+	heartbeatCtx, stopHeartbeat := context.WithCancel(jobCtx)
+	defer stopHeartbeat()
+	heartbeatDone := make(chan struct{})
+	go func() {
+		runHeartbeat(heartbeatCtx, db, names)
+		close(heartbeatDone)
+	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		run(ctx, jobCtx, newPostgresQueue(db), wake, names)
+		close(done)
+	}()
 
+	log.Println("Worker is running...")
 	log.Println("Press Ctrl+C to stop the worker")
-	<-quit
+	<-ctx.Done()
 
-	shutdown <- 1
+	drainTimeout := getEnvDuration("DRAIN_TIMEOUT", 30*time.Second)
+	log.Printf("Worker is shutting down, draining in-flight jobs for up to %s\n", drainTimeout)
 
-	log.Println("Worker is shutting down")
-}
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Println("Drain timeout reached, cancelling in-flight jobs")
+		cancelJobs()
 
-func setupDatabase() (*gorm.DB, error) {
-	// Get database connection info from environment variables
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-		"postgres", "worker", "password", "workerdb", "5432")
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Println("Jobs ignored cancellation, leaving them to the reaper")
+			return
+		}
+	}
+
+	stopHeartbeat()
+	<-heartbeatDone
+	if err := removeHeartbeats(context.Background(), db, names); err != nil {
+		log.Println("Failed to remove heartbeats:", err)
+	}
+
+	log.Println("Worker stopped")
+}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+func setupDatabase(cfg DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(cfg.ConnString()), &gorm.Config{
+		Logger: logger.Default.LogMode(logLevels[cfg.LogLevel]),
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+
 	log.Println("Database connected successfully")
 	return db, nil
 }
 
-func run(ctx context.Context, shutdown chan int, db *gorm.DB) {
+// workerNames derives the names of the CONCURRENCY worker loops of this
+// process from WORKER_ID.
+func workerNames() []string {
+	workerID := getEnv("WORKER_ID", "unknown-worker")
+	concurrency := max(getEnvInt("CONCURRENCY", 1), 1)
+
+	names := make([]string, concurrency)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", workerID, i+1)
+	}
+	return names
+}
+
+// run starts one worker loop per name sharing db and waits for all of them to
+// stop.
+func run(ctx, jobCtx context.Context, q Queue, wake *waker, names []string) {
+	var wg sync.WaitGroup
+	for _, workerName := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runLoop(ctx, jobCtx, q, wake, workerName)
+		}()
+	}
+	wg.Wait()
+}
+
+// runLoop claims work until ctx is cancelled. When nothing is due it sleeps
+// with a growing backoff, cut short by a NOTIFY from the listener.
+func runLoop(ctx, jobCtx context.Context, q Queue, wake *waker, workerName string) {
+	minIdle := getEnvDuration("IDLE_MIN_DELAY", time.Second)
+	maxIdle := getEnvDuration("IDLE_MAX_DELAY", 30*time.Second)
+	idle := minIdle
+
 	for {
+		if ctx.Err() != nil {
+			log.Printf("%s : Shutting down...\n", workerName)
+			return
+		}
+
+		woken := wake.C()
+		if runWorker(ctx, jobCtx, q, workerName) > 0 {
+			idle = minIdle
+			continue
+		}
+
 		select {
-		case <-shutdown:
-			log.Println("Shutting down...")
+		case <-ctx.Done():
+			log.Printf("%s : Shutting down...\n", workerName)
 			return
+		case <-woken:
+			idle = minIdle
+		case <-time.After(idle):
+			idle = min(idle*2, maxIdle)
+		}
+	}
+}
+
+// runWorker claims and processes one batch and returns its size. Claimed rows
+// that are not started before shutdown are released back to pending.
+func runWorker(ctx, jobCtx context.Context, q Queue, workerName string) int {
+	workers, err := q.Claim(ctx, workerName, max(getEnvInt("BATCH_SIZE", 1), 1))
+	if err != nil {
+		log.Println("Failed to claim workers:", err)
+		return 0
+	}
+	if len(workers) == 0 {
+		return 0
+	}
+	for _, worker := range workers {
+		jobsClaimed.WithLabelValues(worker.JobType, workerName).Inc()
+	}
+	currentJobs.hold(workers)
+
+	// The rows are already committed as running, so the work happens
+	// without holding any row lock.
+	for i := range workers {
+		worker := &workers[i]
+
+		var err error
+		switch {
+		case ctx.Err() != nil:
+			err = q.Release(context.WithoutCancel(ctx), workerName, worker)
+		case i > 0 && cancelledWhileWaiting(ctx, q, workerName, worker):
+			jobsCancelled.WithLabelValues(worker.JobType, workerName).Inc()
+			err = q.Cancel(context.WithoutCancel(ctx), workerName, worker)
 		default:
-			log.Println("Run2!")
-			runWorker(ctx, db)
-			// time.Sleep(5 * time.Second)
+			err = processWorker(jobCtx, q, workerName, worker)
 		}
+		if err != nil {
+			log.Printf("%s : Failed to record result of worker ID %d: %v\n", workerName, worker.ID, err)
+		}
+		currentJobs.drop(worker.ID)
 	}
+	return len(workers)
 }
 
-func runWorker(ctx context.Context, db *gorm.DB) {
-	workerName := getEnv("WORKER_ID", "unknown-worker")
-	var worker Worker
+// cancelledWhileWaiting reports whether the cancellation of a claimed row was
+// requested while it waited for its turn in the batch. Its handler was not
+// running yet, so nothing else stops it from starting.
+func cancelledWhileWaiting(ctx context.Context, q Queue, workerName string, worker *Worker) bool {
+	current, err := q.Get(ctx, worker.ID)
+	if err != nil {
+		log.Printf("%s : Failed to check worker ID %d before starting it: %v\n", workerName, worker.ID, err)
+		return false
+	}
+	if current.Status != StatusCancelRequested {
+		return false
+	}
+	log.Printf("%s : Worker ID %d was cancelled before it started\n", workerName, worker.ID)
+	return true
+}
 
-	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+// claimWorkers locks up to batchSize due rows, marks them running with a lease
+// and commits right away so the locks are held only for the claim itself.
+// Limited queues are served first within their allowance, then the rest.
+// When queues is not empty only those queues are claimed from.
+func claimWorkers(ctx context.Context, db *gorm.DB, workerName string, batchSize int, queues []string) ([]Worker, error) {
+	var workers []Worker
+
+	start := time.Now()
+	defer func() { claimDuration.Observe(time.Since(start).Seconds()) }()
 
-		// With row locking, worker logs will be unique
-		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("status = ?", "pending").First(&worker).Error; err != nil {
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		limits, err := lockQueueLimits(tx)
+		if err != nil {
+			return err
+		}
+		rotation, err := loadTenantRotation(tx)
+		if err != nil {
 			return err
 		}
-		// Without row locking, worker logs will be duplicated
-		// if err := tx.Where("status = ?", "pending").First(&worker).Error; err != nil {
-		// 	return err
-		// }
 
-		log.Printf("%s : Acquired lock on worker ID: %d\n", workerName, worker.ID)
+		for _, queue := range limits.available {
+			n := min(queue.allowance, batchSize-len(workers))
+			if n <= 0 || (len(queues) > 0 && !slices.Contains(queues, queue.config.Name)) {
+				continue
+			}
+			claimed, err := selectDueFair(tx.Where("queue = ?", queue.config.Name), n, rotation)
+			if err != nil {
+				return err
+			}
+			queue.claimed = len(claimed)
+			workers = append(workers, claimed...)
+		}
 
-		worker.Status = "finished"
+		if n := batchSize - len(workers); n > 0 {
+			rest := tx
+			if len(limits.configured) > 0 {
+				rest = rest.Where("queue NOT IN ?", limits.configured)
+			}
+			if len(queues) > 0 {
+				rest = rest.Where("queue IN ?", queues)
+			}
+			claimed, err := selectDueFair(rest, n, rotation)
+			if err != nil {
+				return err
+			}
+			workers = append(workers, claimed...)
+		}
 
-		if err := tx.Save(&worker).Error; err != nil {
+		if err := limits.save(tx); err != nil {
 			return err
 		}
 
+		if len(workers) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(workers))
+		for i, worker := range workers {
+			ids[i] = worker.ID
+		}
+
+		leaseExpiresAt := time.Now().Add(leaseDuration())
+
+		if err := tx.Model(&Worker{}).Where("id IN ?", ids).Updates(map[string]any{
+			"status":           StatusRunning,
+			"attempts":         gorm.Expr("attempts + 1"),
+			"locked_by":        workerName,
+			"lease_expires_at": leaseExpiresAt,
+			"progress":         0,
+			"progress_message": nil,
+			"result":           nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		for i := range workers {
+			workers[i].Status = StatusRunning
+			workers[i].Attempts++
+			workers[i].LockedBy = &workerName
+			workers[i].LeaseExpiresAt = &leaseExpiresAt
+			workers[i].Progress = 0
+			workers[i].ProgressMessage = nil
+			workers[i].Result = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return workers, nil
+}
+
+// selectDue locks up to limit due rows matching the conditions already set
+// on query.
+func selectDue(query *gorm.DB, limit int) ([]Worker, error) {
+	var workers []Worker
+
+	// With row locking, worker logs will be unique
+	if err := query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND run_at <= ?", StatusPending, time.Now()).
+		Where(parentsFinished).
+		Order("priority DESC, run_at, id").
+		Limit(limit).
+		Find(&workers).Error; err != nil {
+		return nil, err
+	}
+	// Without row locking, worker logs will be duplicated
+	// if err := query.Where("status = ?", "pending").Order("priority DESC, run_at, id").Limit(limit).Find(&workers).Error; err != nil {
+	// 	return nil, err
+	// }
+
+	return workers, nil
+}
+
+// processWorker runs the job of a claimed row and records the outcome. The
+// outcome is written even when ctx got cancelled while the job was running.
+func processWorker(ctx context.Context, q Queue, workerName string, worker *Worker) error {
+	log.Printf("%s : Acquired lock on worker ID: %d type: %s (attempt %d/%d)\n", workerName, worker.ID, worker.JobType, worker.Attempts, worker.MaxAttempts)
+
+	// handlerCtx is also cancelled when the job is cancelled while it runs.
+	handlerCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	currentJobs.start(workerName, worker.ID, cancel)
+	defer currentJobs.done(workerName)
+
+	start := time.Now()
+	err := processJob(withJob(handlerCtx, q, worker), worker)
+	jobDuration.WithLabelValues(worker.JobType).Observe(time.Since(start).Seconds())
+
+	writeCtx := context.WithoutCancel(ctx)
+
+	switch {
+	case err == nil:
+		jobsFinished.WithLabelValues(worker.JobType, workerName).Inc()
+		return q.Complete(writeCtx, workerName, worker)
+	case ctx.Err() != nil:
+		// Interrupted by shutdown, not the job's fault.
+		return q.Release(writeCtx, workerName, worker)
+	case errors.Is(context.Cause(handlerCtx), errCancelRequested):
+		jobsCancelled.WithLabelValues(worker.JobType, workerName).Inc()
+		return q.Cancel(writeCtx, workerName, worker)
+	default:
+		jobsFailed.WithLabelValues(worker.JobType, workerName).Inc()
+		return q.Fail(writeCtx, workerName, worker, err)
+	}
+}
+
+// processJob runs the handler registered for the job type.
+func processJob(ctx context.Context, worker *Worker) error {
+	handler, exist := handlers[worker.JobType]
+	if !exist {
+		return Permanent(fmt.Errorf("no handler registered for job type %q", worker.JobType))
+	}
+	return handler(ctx, worker.Payload)
+}
+
+// finishWorker marks a running row finished and writes its worker log. A row
+// whose cancellation was requested is finished too, the work is done anyway.
+func finishWorker(ctx context.Context, db *gorm.DB, workerName string, worker *Worker) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(worker).Where("status IN ? AND locked_by = ?", claimedStatuses, workerName).Updates(map[string]any{
+			"status":           StatusFinished,
+			"last_error":       nil,
+			"locked_by":        nil,
+			"lease_expires_at": nil,
+			"progress":         100,
+			"result":           worker.Result,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errLeaseLost
+		}
+		worker.Status = StatusFinished
+		worker.Progress = 100
+		worker.LastError = nil
+		worker.LockedBy = nil
+		worker.LeaseExpiresAt = nil
+
 		// save worker logs
 		now := time.Now()
 		WorkerLog := WorkerLog{
 			WorkerID:   worker.ID,
+			Status:     StatusFinished,
 			FinishedAt: &now,
 			WorkerName: workerName,
 		}
@@ -117,15 +500,150 @@ func runWorker(ctx context.Context, db *gorm.DB) {
 			return err
 		}
 
-		// random sleep between 2-5 seconds to simulate work
-		sleepDuration := time.Duration(2+time.Now().UnixNano()%10) * time.Second
-		time.Sleep(sleepDuration)
+		if err := notifyDependents(tx, worker.ID); err != nil {
+			return err
+		}
 
-		return nil
-	}); err != nil {
-		log.Println("No pending worker found or error occurred:", err)
+		if worker.IdempotencyKey == nil {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&JobCompletion{
+			IdempotencyKey: *worker.IdempotencyKey,
+			WorkerID:       worker.ID,
+			CompletedAt:    now,
+		}).Error
+	})
+}
+
+// failWorker records a failed attempt. The row goes back to pending with an
+// exponential backoff, or to dead once it has used up its attempts. A row
+// whose cancellation was requested is cancelled instead.
+func failWorker(ctx context.Context, db *gorm.DB, workerName string, worker *Worker, cause error) error {
+	// Only the caller's copy changes once the row is known to be ours.
+	failed := *worker
+	applyFailure(&failed, cause)
+
+	res := db.WithContext(ctx).Model(&Worker{}).Where("id = ? AND status = ? AND locked_by = ?", worker.ID, StatusRunning, workerName).Updates(map[string]any{
+		"status":           failed.Status,
+		"run_at":           failed.RunAt,
+		"last_error":       failed.LastError,
+		"result":           failed.Result,
+		"locked_by":        nil,
+		"lease_expires_at": nil,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return cancelRunningWorker(ctx, db, workerName, worker)
+	}
+	*worker = failed
+	logFailure(workerName, worker, cause)
+
+	if worker.Status == StatusDead {
+		propagateFailuresAfter(ctx, db, worker)
+	}
+	return nil
+}
+
+// applyFailure moves worker to its status after a failed attempt, shared by
+// every Queue implementation.
+func applyFailure(worker *Worker, cause error) {
+	msg := cause.Error()
+	worker.LastError = &msg
+	worker.LockedBy = nil
+	worker.LeaseExpiresAt = nil
+
+	if isPermanent(cause) || worker.Attempts >= worker.MaxAttempts {
+		worker.Status = StatusDead
+	} else {
+		worker.Status = StatusPending
+		worker.RunAt = time.Now().Add(retryBackoff(worker.Attempts))
+	}
+}
+
+// logFailure logs the status applyFailure moved worker to, once recorded.
+func logFailure(workerName string, worker *Worker, cause error) {
+	if worker.Status == StatusDead {
+		log.Printf("%s : Worker ID %d is dead after %d attempts: %v\n", workerName, worker.ID, worker.Attempts, cause)
 		return
 	}
+	log.Printf("%s : Worker ID %d failed, retrying in %s: %v\n", workerName, worker.ID, time.Until(worker.RunAt).Round(time.Second), cause)
+}
+
+// releaseWorker hands a running row back to pending without counting the
+// attempt, used when the worker stops before the job could complete. A row
+// whose cancellation was requested is cancelled instead.
+func releaseWorker(ctx context.Context, db *gorm.DB, workerName string, worker *Worker) error {
+	res := db.WithContext(ctx).Model(&Worker{}).Where("id = ? AND status = ? AND locked_by = ?", worker.ID, StatusRunning, workerName).Updates(map[string]any{
+		"status":           StatusPending,
+		"attempts":         gorm.Expr("attempts - 1"),
+		"locked_by":        nil,
+		"lease_expires_at": nil,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return cancelRunningWorker(ctx, db, workerName, worker)
+	}
+
+	log.Printf("%s : Released worker ID %d back to pending\n", workerName, worker.ID)
+	return nil
+}
+
+// cancelRunningWorker marks a row held by workerName whose cancellation was
+// requested cancelled, writes its worker log and fails its dependents.
+func cancelRunningWorker(ctx context.Context, db *gorm.DB, workerName string, worker *Worker) error {
+	if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Worker{}).Where("id = ? AND status = ? AND locked_by = ?", worker.ID, StatusCancelRequested, workerName).Updates(map[string]any{
+			"status":           StatusCancelled,
+			"result":           worker.Result,
+			"locked_by":        nil,
+			"lease_expires_at": nil,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errLeaseLost
+		}
+
+		now := time.Now()
+		return tx.Create(&WorkerLog{
+			WorkerID:   worker.ID,
+			Status:     StatusCancelled,
+			FinishedAt: &now,
+			WorkerName: workerName,
+		}).Error
+	}); err != nil {
+		return err
+	}
+	worker.Status = StatusCancelled
+	worker.LockedBy = nil
+	worker.LeaseExpiresAt = nil
+
+	log.Printf("%s : Cancelled worker ID %d\n", workerName, worker.ID)
+	propagateFailuresAfter(ctx, db, worker)
+	return nil
+}
+
+// leaseDuration is how long a claimed row stays ours without a heartbeat.
+func leaseDuration() time.Duration {
+	return getEnvDuration("LEASE_DURATION", 5*time.Minute)
+}
+
+// retryBackoff doubles RETRY_BASE_DELAY for every attempt already made,
+// capped at RETRY_MAX_DELAY.
+func retryBackoff(attempts int) time.Duration {
+	base := getEnvDuration("RETRY_BASE_DELAY", 5*time.Second)
+	limit := getEnvDuration("RETRY_MAX_DELAY", 10*time.Minute)
+
+	delay := base
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -134,3 +652,17 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
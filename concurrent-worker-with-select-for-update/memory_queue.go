@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var _ Queue = (*memoryQueue)(nil)
+
+// memoryQueue keeps jobs in process with the same semantics as postgresQueue,
+// so handlers and the worker loop can run without a database. Queue limits
+// and tenant weights are not enforced, tenants simply take turns, and there is
+// no reaper: a lease only ends through Complete, Fail or Release.
+type memoryQueue struct {
+	mu sync.Mutex
+	// queues restricts Claim to these queues when not empty.
+	queues      []string
+	nextID      uint
+	jobs        map[uint]*Worker
+	parents     map[uint][]uint
+	completions map[string]uint
+	logs        []WorkerLog
+}
+
+func newMemoryQueue(queues ...string) *memoryQueue {
+	return &memoryQueue{
+		queues:      queues,
+		jobs:        map[uint]*Worker{},
+		parents:     map[uint][]uint{},
+		completions: map[string]uint{},
+	}
+}
+
+func (q *memoryQueue) Enqueue(ctx context.Context, jobType string, payload any, opts EnqueueOptions) (*Worker, error) {
+	worker, err := newWorker(jobType, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, existing := range q.jobs {
+		if worker.IdempotencyKey != nil && existing.IdempotencyKey != nil && *existing.IdempotencyKey == *worker.IdempotencyKey {
+			return copyWorker(existing), nil
+		}
+		if worker.UniqueKey != nil && existing.UniqueKey != nil && *existing.UniqueKey == *worker.UniqueKey && isActive(existing.Status) {
+			return copyWorker(existing), nil
+		}
+	}
+	for _, parentID := range opts.DependsOn {
+		if _, exist := q.jobs[parentID]; !exist {
+			return nil, fmt.Errorf("parent job %d does not exist", parentID)
+		}
+	}
+
+	q.nextID++
+	now := time.Now()
+	worker.ID = q.nextID
+	worker.CreatedAt = now
+	worker.UpdatedAt = now
+	worker.DependsOn = opts.DependsOn
+	q.jobs[worker.ID] = &worker
+	if len(opts.DependsOn) > 0 {
+		q.parents[worker.ID] = slices.Clone(opts.DependsOn)
+	}
+	q.propagateFailures()
+
+	return copyWorker(&worker), nil
+}
+
+func (q *memoryQueue) Claim(ctx context.Context, workerName string, limit int) ([]Worker, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var due []*Worker
+	for _, job := range q.jobs {
+		if job.Status != StatusPending || job.RunAt.After(now) || !q.parentsFinished(job.ID) {
+			continue
+		}
+		if len(q.queues) > 0 && !slices.Contains(q.queues, job.Queue) {
+			continue
+		}
+		due = append(due, job)
+	}
+
+	// ORDER BY priority DESC, run_at, id
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].Priority != due[j].Priority {
+			return due[i].Priority > due[j].Priority
+		}
+		if !due[i].RunAt.Equal(due[j].RunAt) {
+			return due[i].RunAt.Before(due[j].RunAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+
+	if getEnv("FAIR_CLAIM", fairWeighted) != fairOff {
+		due = q.takeTurns(due)
+	}
+
+	leaseExpiresAt := now.Add(leaseDuration())
+	claimed := make([]Worker, 0, min(limit, len(due)))
+	for _, job := range due[:min(limit, len(due))] {
+		job.Status = StatusRunning
+		job.Attempts++
+		job.LockedBy = &workerName
+		job.LeaseExpiresAt = &leaseExpiresAt
+		job.Progress = 0
+		job.ProgressMessage = nil
+		job.Result = nil
+		job.UpdatedAt = now
+		claimed = append(claimed, *copyWorker(job))
+	}
+	return claimed, nil
+}
+
+func (q *memoryQueue) Complete(ctx context.Context, workerName string, worker *Worker) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.owned(workerName, worker.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	job.Status = StatusFinished
+	job.Progress = 100
+	job.Result = worker.Result
+	job.LastError = nil
+	job.LockedBy = nil
+	job.LeaseExpiresAt = nil
+	job.UpdatedAt = now
+
+	q.log(workerName, job)
+	if job.IdempotencyKey != nil {
+		if _, exist := q.completions[*job.IdempotencyKey]; !exist {
+			q.completions[*job.IdempotencyKey] = job.ID
+		}
+	}
+
+	*worker = *copyWorker(job)
+	return nil
+}
+
+func (q *memoryQueue) Fail(ctx context.Context, workerName string, worker *Worker, cause error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.owned(workerName, worker.ID)
+	if err != nil {
+		return err
+	}
+
+	job.Result = worker.Result
+	if job.Status == StatusCancelRequested {
+		q.cancel(workerName, job)
+		*worker = *copyWorker(job)
+		return nil
+	}
+
+	applyFailure(job, cause)
+	logFailure(workerName, job, cause)
+	job.UpdatedAt = time.Now()
+	if job.Status == StatusDead {
+		q.propagateFailures()
+	}
+
+	*worker = *copyWorker(job)
+	return nil
+}
+
+func (q *memoryQueue) Release(ctx context.Context, workerName string, worker *Worker) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.owned(workerName, worker.ID)
+	if err != nil {
+		return err
+	}
+
+	if job.Status == StatusCancelRequested {
+		q.cancel(workerName, job)
+	} else {
+		job.Status = StatusPending
+		job.Attempts--
+		job.LockedBy = nil
+		job.LeaseExpiresAt = nil
+		job.UpdatedAt = time.Now()
+	}
+
+	*worker = *copyWorker(job)
+	return nil
+}
+
+func (q *memoryQueue) Cancel(ctx context.Context, workerName string, worker *Worker) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.owned(workerName, worker.ID)
+	if err != nil || job.Status != StatusCancelRequested {
+		return errLeaseLost
+	}
+
+	job.Result = worker.Result
+	q.cancel(workerName, job)
+	*worker = *copyWorker(job)
+	return nil
+}
+
+func (q *memoryQueue) RequestCancel(ctx context.Context, id uint) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, exist := q.jobs[id]
+	if !exist {
+		return ErrWorkerNotFound
+	}
+
+	switch job.Status {
+	case StatusPending:
+		job.Status = StatusCancelled
+		job.UpdatedAt = time.Now()
+		q.propagateFailures()
+	case StatusRunning:
+		job.Status = StatusCancelRequested
+		job.UpdatedAt = time.Now()
+		// The handler runs in this process, there is nobody else to notify.
+		currentJobs.cancel(id)
+	default:
+		return ErrInvalidStatus
+	}
+	return nil
+}
+
+func (q *memoryQueue) Progress(ctx context.Context, workerName string, id uint, percent int, message string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.owned(workerName, id)
+	if err != nil {
+		return err
+	}
+
+	job.Progress = percent
+	job.ProgressMessage = nil
+	if message != "" {
+		job.ProgressMessage = &message
+	}
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+func (q *memoryQueue) Get(ctx context.Context, id uint) (*Worker, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, exist := q.jobs[id]
+	if !exist {
+		return nil, ErrWorkerNotFound
+	}
+	return copyWorker(job), nil
+}
+
+func (q *memoryQueue) Completed(ctx context.Context, idempotencyKey string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, exist := q.completions[idempotencyKey]
+	return exist, nil
+}
+
+func (q *memoryQueue) MarkCompleted(ctx context.Context, idempotencyKey string, id uint) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exist := q.completions[idempotencyKey]; !exist {
+		q.completions[idempotencyKey] = id
+	}
+	return nil
+}
+
+// takeTurns interleaves due, already in claim order, one job per tenant at a
+// time, starting one tenant further on every claim like selectDueFair.
+func (q *memoryQueue) takeTurns(due []*Worker) []*Worker {
+	byTenant := map[string][]*Worker{}
+	var tenants []string
+	for _, job := range due {
+		if _, exist := byTenant[job.TenantID]; !exist {
+			tenants = append(tenants, job.TenantID)
+		}
+		byTenant[job.TenantID] = append(byTenant[job.TenantID], job)
+	}
+	if len(tenants) < 2 {
+		return due
+	}
+	slices.Sort(tenants)
+
+	start := int(tenantCursor.Add(1) % uint64(len(tenants)))
+	fair := make([]*Worker, 0, len(due))
+	for len(fair) < len(due) {
+		for i := range tenants {
+			tenant := tenants[(start+i)%len(tenants)]
+			if jobs := byTenant[tenant]; len(jobs) > 0 {
+				fair = append(fair, jobs[0])
+				byTenant[tenant] = jobs[1:]
+			}
+		}
+	}
+	return fair
+}
+
+// owned returns job id while it is held under workerName's lease.
+func (q *memoryQueue) owned(workerName string, id uint) (*Worker, error) {
+	job, exist := q.jobs[id]
+	if !exist || !slices.Contains(claimedStatuses, job.Status) || job.LockedBy == nil || *job.LockedBy != workerName {
+		return nil, errLeaseLost
+	}
+	return job, nil
+}
+
+// cancel ends a held job whose cancellation was requested.
+func (q *memoryQueue) cancel(workerName string, job *Worker) {
+	job.Status = StatusCancelled
+	job.LockedBy = nil
+	job.LeaseExpiresAt = nil
+	job.UpdatedAt = time.Now()
+	q.log(workerName, job)
+	q.propagateFailures()
+}
+
+// log writes the worker log of a job that stopped for good.
+func (q *memoryQueue) log(workerName string, job *Worker) {
+	now := time.Now()
+	q.logs = append(q.logs, WorkerLog{
+		ID:         uint(len(q.logs) + 1),
+		WorkerID:   job.ID,
+		Status:     job.Status,
+		FinishedAt: &now,
+		WorkerName: workerName,
+	})
+}
+
+func (q *memoryQueue) parentsFinished(id uint) bool {
+	for _, parentID := range q.parents[id] {
+		if parent, exist := q.jobs[parentID]; exist && parent.Status != StatusFinished {
+			return false
+		}
+	}
+	return true
+}
+
+// propagateFailures mirrors the Postgres version: pending jobs with a dead or
+// cancelled parent are marked dead, down the whole chain.
+func (q *memoryQueue) propagateFailures() {
+	for changed := true; changed; {
+		changed = false
+		for id, parentIDs := range q.parents {
+			job := q.jobs[id]
+			if job.Status != StatusPending {
+				continue
+			}
+			for _, parentID := range parentIDs {
+				parent, exist := q.jobs[parentID]
+				if !exist || (parent.Status != StatusDead && parent.Status != StatusCancelled) {
+					continue
+				}
+				msg := fmt.Sprintf("parent job %d is %s", parent.ID, parent.Status)
+				job.Status = StatusDead
+				job.LastError = &msg
+				job.UpdatedAt = time.Now()
+				changed = true
+				break
+			}
+		}
+	}
+}
+
+func isActive(status string) bool {
+	return slices.Contains(activeStatuses, status)
+}
+
+func copyWorker(worker *Worker) *Worker {
+	c := *worker
+	c.Payload = slices.Clone(worker.Payload)
+	c.Result = slices.Clone(worker.Result)
+	c.DependsOn = slices.Clone(worker.DependsOn)
+	return &c
+}
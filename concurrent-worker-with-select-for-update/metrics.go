@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+var (
+	jobsClaimed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "jobs_claimed_total",
+		Help:      "Jobs claimed, by job type and worker name.",
+	}, []string{"job_type", "worker"})
+
+	jobsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "jobs_finished_total",
+		Help:      "Jobs finished successfully, by job type and worker name.",
+	}, []string{"job_type", "worker"})
+
+	jobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "jobs_failed_total",
+		Help:      "Failed job attempts, by job type and worker name.",
+	}, []string{"job_type", "worker"})
+
+	jobsCancelled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "worker",
+		Name:      "jobs_cancelled_total",
+		Help:      "Claimed jobs stopped by a cancel request, by job type and worker name.",
+	}, []string{"job_type", "worker"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "job_duration_seconds",
+		Help:      "Time spent in the job handler, by job type.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"job_type"})
+
+	claimDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "worker",
+		Name:      "claim_duration_seconds",
+		Help:      "Time spent in the claim transaction, including waiting for row locks.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	queueDepthDesc = prometheus.NewDesc(
+		"worker_queue_depth",
+		"Rows in the workers table, by status.",
+		[]string{"status"}, nil,
+	)
+)
+
+// queueDepthCollector counts the workers table by status on every scrape.
+type queueDepthCollector struct {
+	db *gorm.DB
+}
+
+func (c *queueDepthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+}
+
+func (c *queueDepthCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := c.db.WithContext(ctx).Model(&Worker{}).
+		Select("status, count(*) AS count").
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		log.Println("Failed to collect queue depth:", err)
+		return
+	}
+
+	for _, row := range rows {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(row.Count), row.Status)
+	}
+}
+
+func registerMetrics(db *gorm.DB) {
+	prometheus.MustRegister(
+		jobsClaimed,
+		jobsFinished,
+		jobsFailed,
+		jobsCancelled,
+		jobDuration,
+		claimDuration,
+		&queueDepthCollector{db: db},
+	)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID serializes migrations when several processes run them at
+// the same time, as app1..app3 do on startup.
+const migrationLockID = 727_001
+
+// SchemaMigration is a row of schema_migrations, one per applied version.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"type:timestamptz;not null"`
+}
+
+// migration is a pair of NNNN_name.up.sql and NNNN_name.down.sql files.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads the embedded migrations ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		prefix, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q, want NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+
+		data, err := fs.ReadFile(migrationFiles, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exist := byVersion[version]
+		if !exist {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// lockMigrations takes the transaction scoped migration lock and makes sure
+// schema_migrations exists.
+func lockMigrations(tx *gorm.DB) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error; err != nil {
+		return err
+	}
+	return tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`).Error
+}
+
+func appliedVersions(tx *gorm.DB) (map[int]SchemaMigration, error) {
+	var rows []SchemaMigration
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// migrateUp applies every pending migration, each in its own transaction,
+// and returns the versions it applied.
+func migrateUp(ctx context.Context, db *gorm.DB) ([]int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for _, m := range migrations {
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := lockMigrations(tx); err != nil {
+				return err
+			}
+			applied, err := appliedVersions(tx)
+			if err != nil {
+				return err
+			}
+			if _, exist := applied[m.Version]; exist {
+				return nil
+			}
+
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return fmt.Errorf("apply %d_%s: %w", m.Version, m.Name, err)
+			}
+			if err := tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+			done = append(done, m.Version)
+			return nil
+		})
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// migrateDown reverts the latest steps applied migrations.
+func migrateDown(ctx context.Context, db *gorm.DB, steps int) ([]int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []int
+	for range steps {
+		reverted := false
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := lockMigrations(tx); err != nil {
+				return err
+			}
+			applied, err := appliedVersions(tx)
+			if err != nil {
+				return err
+			}
+
+			for i := len(migrations) - 1; i >= 0; i-- {
+				m := migrations[i]
+				if _, exist := applied[m.Version]; !exist {
+					continue
+				}
+
+				if err := tx.Exec(m.Down).Error; err != nil {
+					return fmt.Errorf("revert %d_%s: %w", m.Version, m.Name, err)
+				}
+				if err := tx.Delete(&SchemaMigration{Version: m.Version}).Error; err != nil {
+					return err
+				}
+				reverted = true
+				done = append(done, m.Version)
+				return nil
+			}
+			return nil
+		})
+		if err != nil || !reverted {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// appliedMigrations returns the rows of schema_migrations by version.
+func appliedMigrations(ctx context.Context, db *gorm.DB) (map[int]SchemaMigration, error) {
+	var applied map[int]SchemaMigration
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockMigrations(tx); err != nil {
+			return err
+		}
+		var err error
+		applied, err = appliedVersions(tx)
+		return err
+	})
+	return applied, err
+}
+
+// pendingMigrations lists the embedded migrations not applied yet.
+func pendingMigrations(ctx context.Context, db *gorm.DB) ([]migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if _, exist := applied[m.Version]; !exist {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
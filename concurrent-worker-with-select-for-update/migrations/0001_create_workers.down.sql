@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS worker_logs;
+DROP TABLE IF EXISTS workers;
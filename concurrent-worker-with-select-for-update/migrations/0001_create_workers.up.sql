@@ -0,0 +1,13 @@
+-- The schema of the original init.sql, a no-op on databases created by it
+CREATE TABLE IF NOT EXISTS workers (
+    id SERIAL PRIMARY KEY,
+    status VARCHAR(50) NOT NULL DEFAULT 'pending'
+);
+
+CREATE TABLE IF NOT EXISTS worker_logs (
+    id SERIAL PRIMARY KEY,
+    worker_id INT NOT NULL,
+    finished_at TIMESTAMPTZ,
+    worker_name varchar(100),
+    FOREIGN KEY (worker_id) REFERENCES workers(id) ON DELETE CASCADE
+);
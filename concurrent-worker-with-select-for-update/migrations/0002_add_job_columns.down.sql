@@ -0,0 +1,20 @@
+DROP TRIGGER IF EXISTS workers_pending_notify ON workers;
+DROP FUNCTION IF EXISTS notify_workers_pending();
+DROP TABLE IF EXISTS schedules;
+DROP TABLE IF EXISTS job_completions;
+DROP INDEX IF EXISTS workers_unique_key_idx;
+DROP INDEX IF EXISTS workers_idempotency_key_idx;
+DROP INDEX IF EXISTS workers_claim_idx;
+ALTER TABLE workers DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE workers DROP COLUMN IF EXISTS created_at;
+ALTER TABLE workers DROP COLUMN IF EXISTS last_error;
+ALTER TABLE workers DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE workers DROP COLUMN IF EXISTS locked_by;
+ALTER TABLE workers DROP COLUMN IF EXISTS run_at;
+ALTER TABLE workers DROP COLUMN IF EXISTS max_attempts;
+ALTER TABLE workers DROP COLUMN IF EXISTS attempts;
+ALTER TABLE workers DROP COLUMN IF EXISTS idempotency_key;
+ALTER TABLE workers DROP COLUMN IF EXISTS unique_key;
+ALTER TABLE workers DROP COLUMN IF EXISTS priority;
+ALTER TABLE workers DROP COLUMN IF EXISTS payload;
+ALTER TABLE workers DROP COLUMN IF EXISTS job_type;
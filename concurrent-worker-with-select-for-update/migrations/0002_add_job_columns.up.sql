@@ -0,0 +1,56 @@
+-- Job type and payload, priority, deduplication keys, retries and leases
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS job_type VARCHAR(100) NOT NULL DEFAULT 'simulate';
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS payload JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS unique_key VARCHAR(255);
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS max_attempts INT NOT NULL DEFAULT 5;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS run_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS locked_by VARCHAR(100);
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Backs the claim query: due pending rows by priority DESC, run_at, id
+CREATE INDEX IF NOT EXISTS workers_claim_idx ON workers (priority DESC, run_at, id)
+    WHERE status = 'pending';
+
+-- At most one job per idempotency key
+CREATE UNIQUE INDEX IF NOT EXISTS workers_idempotency_key_idx ON workers (idempotency_key);
+
+-- At most one pending or running job per unique key
+CREATE UNIQUE INDEX IF NOT EXISTS workers_unique_key_idx ON workers (unique_key)
+    WHERE unique_key IS NOT NULL AND status IN ('pending', 'running');
+
+-- One row per idempotency key whose job finished
+CREATE TABLE IF NOT EXISTS job_completions (
+    idempotency_key VARCHAR(255) PRIMARY KEY,
+    worker_id INT NOT NULL,
+    completed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS schedules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    cron_expr VARCHAR(100) NOT NULL,
+    job_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    priority INT NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    next_run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_run_at TIMESTAMPTZ
+);
+
+-- Wake up idle workers listening on workers_pending whenever rows are enqueued
+CREATE OR REPLACE FUNCTION notify_workers_pending() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('workers_pending', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER workers_pending_notify
+    AFTER INSERT ON workers
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_workers_pending();
@@ -0,0 +1,9 @@
+-- A job is only claimable once every parent is finished
+CREATE TABLE IF NOT EXISTS job_dependencies (
+    job_id INT NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    parent_id INT NOT NULL REFERENCES workers(id) ON DELETE CASCADE,
+    PRIMARY KEY (job_id, parent_id),
+    CHECK (job_id <> parent_id)
+);
+
+CREATE INDEX IF NOT EXISTS job_dependencies_parent_id_idx ON job_dependencies (parent_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS queues;
+DROP INDEX IF EXISTS workers_running_queue_idx;
+ALTER TABLE workers DROP COLUMN IF EXISTS queue;
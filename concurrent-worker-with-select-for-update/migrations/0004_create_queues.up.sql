@@ -0,0 +1,14 @@
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS queue VARCHAR(100) NOT NULL DEFAULT 'default';
+
+-- Counts running jobs per queue for the concurrency caps
+CREATE INDEX IF NOT EXISTS workers_running_queue_idx ON workers (queue) WHERE status = 'running';
+
+-- Cluster wide limits per queue, queues without a row are unlimited
+CREATE TABLE IF NOT EXISTS queues (
+    name VARCHAR(100) PRIMARY KEY,
+    max_concurrency INT CHECK (max_concurrency >= 0),
+    rate_per_second DOUBLE PRECISION CHECK (rate_per_second > 0),
+    burst INT NOT NULL DEFAULT 1 CHECK (burst >= 1),
+    tokens DOUBLE PRECISION NOT NULL DEFAULT 0,
+    refilled_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
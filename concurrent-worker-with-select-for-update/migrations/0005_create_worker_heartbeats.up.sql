@@ -0,0 +1,8 @@
+-- One row per worker loop, upserted every HEARTBEAT_INTERVAL
+CREATE TABLE IF NOT EXISTS worker_heartbeats (
+    name VARCHAR(100) PRIMARY KEY,
+    host VARCHAR(255) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL,
+    current_job_id INT
+);
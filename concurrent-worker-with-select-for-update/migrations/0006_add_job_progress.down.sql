@@ -0,0 +1,3 @@
+ALTER TABLE workers DROP COLUMN IF EXISTS result;
+ALTER TABLE workers DROP COLUMN IF EXISTS progress_message;
+ALTER TABLE workers DROP COLUMN IF EXISTS progress;
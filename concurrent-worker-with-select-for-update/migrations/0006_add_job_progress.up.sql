@@ -0,0 +1,4 @@
+-- Progress reported by the handler while a job runs, and its JSON result
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS progress INT NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100);
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS progress_message TEXT;
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS result JSONB;
@@ -0,0 +1,6 @@
+UPDATE workers SET status = 'cancelled', locked_by = NULL, lease_expires_at = NULL WHERE status = 'cancel_requested';
+
+DROP INDEX IF EXISTS workers_running_queue_idx;
+CREATE INDEX IF NOT EXISTS workers_running_queue_idx ON workers (queue) WHERE status = 'running';
+
+ALTER TABLE worker_logs DROP COLUMN IF EXISTS status;
@@ -0,0 +1,6 @@
+-- Worker logs record how a job ended, finished or cancelled
+ALTER TABLE worker_logs ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'finished';
+
+-- A job whose cancellation was requested still counts against its queue
+DROP INDEX IF EXISTS workers_running_queue_idx;
+CREATE INDEX IF NOT EXISTS workers_running_queue_idx ON workers (queue) WHERE status IN ('running', 'cancel_requested');
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS workers_retention_idx;
+DROP TABLE IF EXISTS worker_logs_archive;
+DROP TABLE IF EXISTS workers_archive;
@@ -0,0 +1,19 @@
+-- Finished, dead and cancelled jobs moved out of the hot tables by the
+-- retention job. Same columns as the live tables, without their indexes.
+CREATE TABLE IF NOT EXISTS workers_archive (
+    LIKE workers INCLUDING DEFAULTS,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS worker_logs_archive (
+    LIKE worker_logs INCLUDING DEFAULTS,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS worker_logs_archive_worker_id_idx ON worker_logs_archive (worker_id);
+
+-- Finds terminal rows by age without scanning the pending ones
+CREATE INDEX IF NOT EXISTS workers_retention_idx ON workers (updated_at)
+    WHERE status IN ('finished', 'dead', 'cancelled');
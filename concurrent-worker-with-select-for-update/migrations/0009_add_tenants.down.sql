@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tenants;
+DROP INDEX IF EXISTS workers_tenant_claim_idx;
+ALTER TABLE workers_archive DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE workers DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,13 @@
+ALTER TABLE workers ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(100) NOT NULL DEFAULT 'default';
+ALTER TABLE workers_archive ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(100) NOT NULL DEFAULT 'default';
+
+-- Backs the fair claim: the loose scan over tenants with pending jobs, and
+-- the due rows of one tenant by priority DESC, run_at, id
+CREATE INDEX IF NOT EXISTS workers_tenant_claim_idx ON workers (tenant_id, priority DESC, run_at, id)
+    WHERE status = 'pending';
+
+-- Claim weights, tenants without a row have weight 1
+CREATE TABLE IF NOT EXISTS tenants (
+    name VARCHAR(100) PRIMARY KEY,
+    weight INT NOT NULL DEFAULT 1 CHECK (weight >= 1)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS workers_unique_key_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS workers_unique_key_idx ON workers (unique_key)
+    WHERE unique_key IS NOT NULL AND status IN ('pending', 'running');
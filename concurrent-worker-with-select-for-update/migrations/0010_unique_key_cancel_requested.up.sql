@@ -0,0 +1,4 @@
+-- A job whose cancellation was requested still holds its unique key
+DROP INDEX IF EXISTS workers_unique_key_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS workers_unique_key_idx ON workers (unique_key)
+    WHERE unique_key IS NOT NULL AND status IN ('pending', 'running', 'cancel_requested');
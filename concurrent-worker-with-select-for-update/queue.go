@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Queue is the storage the worker loop claims jobs from. Every
+// implementation must pass the cases in conformance_test.go.
+type Queue interface {
+	// Enqueue adds a pending job, see the package level Enqueue.
+	Enqueue(ctx context.Context, jobType string, payload any, opts EnqueueOptions) (*Worker, error)
+	// Claim marks up to limit due jobs running for workerName.
+	Claim(ctx context.Context, workerName string, limit int) ([]Worker, error)
+	// Complete marks a job claimed by workerName finished.
+	Complete(ctx context.Context, workerName string, worker *Worker) error
+	// Fail records a failed attempt of a job claimed by workerName, which is
+	// retried with a backoff or marked dead.
+	Fail(ctx context.Context, workerName string, worker *Worker, cause error) error
+	// Release hands a job claimed by workerName back without using up the
+	// attempt.
+	Release(ctx context.Context, workerName string, worker *Worker) error
+	// Cancel marks a job claimed by workerName whose cancellation was
+	// requested cancelled, once its handler has stopped.
+	Cancel(ctx context.Context, workerName string, worker *Worker) error
+	// RequestCancel cancels a pending job, or asks the worker of a running job
+	// to stop its handler.
+	RequestCancel(ctx context.Context, id uint) error
+	// Progress records how far a job claimed by workerName is.
+	Progress(ctx context.Context, workerName string, id uint, percent int, message string) error
+	// Get returns the current state of a job.
+	Get(ctx context.Context, id uint) (*Worker, error)
+	// Completed reports whether a job with idempotencyKey has finished, or
+	// marked its side effects done.
+	Completed(ctx context.Context, idempotencyKey string) (bool, error)
+	// MarkCompleted records that job id with idempotencyKey did its side
+	// effects, before the job itself is finished.
+	MarkCompleted(ctx context.Context, idempotencyKey string, id uint) error
+}
+
+var _ Queue = (*postgresQueue)(nil)
+
+// postgresQueue is the FOR UPDATE SKIP LOCKED queue on the workers table.
+type postgresQueue struct {
+	db *gorm.DB
+	// queues restricts Claim to these queues when not empty.
+	queues []string
+}
+
+func newPostgresQueue(db *gorm.DB, queues ...string) *postgresQueue {
+	return &postgresQueue{db: db, queues: queues}
+}
+
+func (q *postgresQueue) Enqueue(ctx context.Context, jobType string, payload any, opts EnqueueOptions) (*Worker, error) {
+	return Enqueue(ctx, q.db, jobType, payload, opts)
+}
+
+func (q *postgresQueue) Claim(ctx context.Context, workerName string, limit int) ([]Worker, error) {
+	return claimWorkers(ctx, q.db, workerName, limit, q.queues)
+}
+
+func (q *postgresQueue) Complete(ctx context.Context, workerName string, worker *Worker) error {
+	return finishWorker(ctx, q.db, workerName, worker)
+}
+
+func (q *postgresQueue) Fail(ctx context.Context, workerName string, worker *Worker, cause error) error {
+	return failWorker(ctx, q.db, workerName, worker, cause)
+}
+
+func (q *postgresQueue) Release(ctx context.Context, workerName string, worker *Worker) error {
+	return releaseWorker(ctx, q.db, workerName, worker)
+}
+
+func (q *postgresQueue) Cancel(ctx context.Context, workerName string, worker *Worker) error {
+	return cancelRunningWorker(ctx, q.db, workerName, worker)
+}
+
+func (q *postgresQueue) RequestCancel(ctx context.Context, id uint) error {
+	return cancelWorker(ctx, q.db, id)
+}
+
+func (q *postgresQueue) Progress(ctx context.Context, workerName string, id uint, percent int, message string) error {
+	return reportProgress(ctx, q.db, workerName, id, percent, message)
+}
+
+func (q *postgresQueue) Get(ctx context.Context, id uint) (*Worker, error) {
+	return getWorker(ctx, q.db, id)
+}
+
+func (q *postgresQueue) Completed(ctx context.Context, idempotencyKey string) (bool, error) {
+	var count int64
+	if err := q.db.WithContext(ctx).Model(&JobCompletion{}).
+		Where("idempotency_key = ?", idempotencyKey).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (q *postgresQueue) MarkCompleted(ctx context.Context, idempotencyKey string, id uint) error {
+	return q.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&JobCompletion{
+		IdempotencyKey: idempotencyKey,
+		WorkerID:       id,
+		CompletedAt:    time.Now(),
+	}).Error
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultQueue is used for jobs enqueued without a queue name.
+const DefaultQueue = "default"
+
+// QueueConfig caps a named queue across the whole cluster: at most
+// MaxConcurrency running jobs, and a token bucket of RatePerSecond starts with
+// room for Burst. Nil limits are not enforced, queues without a row are
+// unlimited.
+type QueueConfig struct {
+	Name           string    `gorm:"primaryKey" json:"name"`
+	MaxConcurrency *int      `json:"max_concurrency,omitempty"`
+	RatePerSecond  *float64  `json:"rate_per_second,omitempty"`
+	Burst          int       `gorm:"not null;default:1" json:"burst"`
+	Tokens         float64   `gorm:"not null;default:0" json:"tokens"`
+	RefilledAt     time.Time `gorm:"type:timestamptz;not null;default:now()" json:"refilled_at"`
+}
+
+func (QueueConfig) TableName() string {
+	return "queues"
+}
+
+// queueAllowance is how many jobs a claim may start from one limited queue.
+type queueAllowance struct {
+	config    QueueConfig
+	allowance int
+	claimed   int
+}
+
+// queueLimits is the state of the limited queues for one claim transaction.
+type queueLimits struct {
+	// configured lists every queue with a config row, these are never
+	// claimed through the unlimited path.
+	configured []string
+	// available are the configs this claim locked, in random order so no
+	// queue is always served last.
+	available []*queueAllowance
+	now       time.Time
+}
+
+// lockQueueLimits locks the queue configs and works out what each allows right
+// now. Configs locked by a concurrent claim are skipped, and so are their
+// queues for this claim, because their counters cannot be trusted.
+func lockQueueLimits(tx *gorm.DB) (*queueLimits, error) {
+	limits := &queueLimits{now: time.Now()}
+
+	if err := tx.Model(&QueueConfig{}).Pluck("name", &limits.configured).Error; err != nil {
+		return nil, err
+	}
+	if len(limits.configured) == 0 {
+		return limits, nil
+	}
+
+	var configs []QueueConfig
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	if len(configs) == 0 {
+		return limits, nil
+	}
+
+	names := make([]string, len(configs))
+	for i, config := range configs {
+		names[i] = config.Name
+	}
+
+	var counts []struct {
+		Queue string
+		Count int
+	}
+	if err := tx.Model(&Worker{}).
+		Select("queue, count(*) AS count").
+		Where("status IN ? AND queue IN ?", claimedStatuses, names).
+		Group("queue").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	running := map[string]int{}
+	for _, count := range counts {
+		running[count.Queue] = count.Count
+	}
+
+	for _, config := range configs {
+		allowance := math.MaxInt
+		if config.MaxConcurrency != nil {
+			allowance = max(*config.MaxConcurrency-running[config.Name], 0)
+		}
+		if config.RatePerSecond != nil {
+			elapsed := limits.now.Sub(config.RefilledAt).Seconds()
+			config.Tokens = min(float64(config.Burst), config.Tokens+max(elapsed, 0)**config.RatePerSecond)
+			allowance = min(allowance, int(config.Tokens))
+		}
+		limits.available = append(limits.available, &queueAllowance{config: config, allowance: allowance})
+	}
+	rand.Shuffle(len(limits.available), func(i, j int) {
+		limits.available[i], limits.available[j] = limits.available[j], limits.available[i]
+	})
+
+	return limits, nil
+}
+
+// save spends the tokens of the jobs claimed from rate limited queues.
+func (l *queueLimits) save(tx *gorm.DB) error {
+	for _, queue := range l.available {
+		if queue.config.RatePerSecond == nil {
+			continue
+		}
+		if err := tx.Model(&QueueConfig{}).Where("name = ?", queue.config.Name).Updates(map[string]any{
+			"tokens":      queue.config.Tokens - float64(queue.claimed),
+			"refilled_at": l.now,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetQueueConfig creates or replaces the limits of a queue. The token bucket
+// starts full.
+func SetQueueConfig(ctx context.Context, db *gorm.DB, config QueueConfig) (*QueueConfig, error) {
+	if config.Name == "" {
+		return nil, errors.New("queue name is required")
+	}
+	if config.MaxConcurrency != nil && *config.MaxConcurrency < 0 {
+		return nil, errors.New("max_concurrency must not be negative")
+	}
+	if config.RatePerSecond != nil && *config.RatePerSecond <= 0 {
+		return nil, errors.New("rate_per_second must be positive")
+	}
+	config.Burst = max(config.Burst, 1)
+	config.Tokens = float64(config.Burst)
+	config.RefilledAt = time.Now()
+
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&config).Error; err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// listQueueConfigs returns every queue config by name.
+func listQueueConfigs(ctx context.Context, db *gorm.DB) ([]QueueConfig, error) {
+	var configs []QueueConfig
+	if err := db.WithContext(ctx).Order("name").Find(&configs).Error; err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
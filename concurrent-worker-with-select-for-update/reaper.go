@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// runReaper periodically releases rows whose lease expired or whose worker
+// stopped sending heartbeats, which happens when the worker holding them
+// crashed or was killed mid-job.
+func runReaper(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(getEnvDuration("REAPER_INTERVAL", 30*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reaped, err := reapExpiredLeases(ctx, db)
+			if err != nil {
+				log.Println("Failed to reap expired leases:", err)
+				continue
+			}
+			if reaped > 0 {
+				log.Printf("Reaper : Released %d worker(s) with an expired lease or a dead owner\n", reaped)
+			}
+
+			// Catches parents that died in the reaper or outside this process.
+			failed, err := propagateFailures(ctx, db)
+			if err != nil {
+				log.Println("Failed to propagate job failures:", err)
+				continue
+			}
+			if failed > 0 {
+				log.Printf("Reaper : Marked %d job(s) with a failed parent dead\n", failed)
+			}
+		}
+	}
+}
+
+// reapExpiredLeases returns running rows to pending, or to dead when the
+// crashed attempt was their last one, and rows whose cancellation was
+// requested to cancelled. A row is reaped once its lease expired, or earlier
+// when the heartbeat of its owner is stale.
+func reapExpiredLeases(ctx context.Context, db *gorm.DB) (int64, error) {
+	now := time.Now()
+	staleOwners := db.Model(&WorkerHeartbeat{}).Select("name").Where("last_seen < ?", now.Add(-heartbeatStaleAfter()))
+
+	res := db.WithContext(ctx).Model(&Worker{}).
+		Where("status IN ?", claimedStatuses).
+		Where(db.Where("lease_expires_at < ?", now).Or("locked_by IN (?)", staleOwners)).
+		Updates(map[string]any{
+			"status": gorm.Expr("CASE WHEN status = ? THEN ? WHEN attempts >= max_attempts THEN ? ELSE ? END",
+				StatusCancelRequested, StatusCancelled, StatusDead, StatusPending),
+			"last_error":       "lease expired",
+			"locked_by":        nil,
+			"lease_expires_at": nil,
+		})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// retentionStatuses are the statuses a job never leaves, the only ones the
+// retention job touches.
+var retentionStatuses = []string{StatusFinished, StatusDead, StatusCancelled}
+
+type retentionPayload struct {
+	// OlderThan is the minimum age since the last update, e.g. 12h or 7d.
+	// RETENTION_AFTER when empty, 7d by default.
+	OlderThan string `json:"older_than"`
+	// Mode is "archive" to move rows into the archive tables or "delete".
+	// RETENTION_MODE when empty, archive by default.
+	Mode string `json:"mode"`
+	// BatchSize is the number of jobs moved per transaction.
+	// RETENTION_BATCH_SIZE when zero, 500 by default.
+	BatchSize int `json:"batch_size"`
+}
+
+// registerRetention runs the retention job on RETENTION_SCHEDULE, every 15
+// minutes by default.
+func registerRetention(ctx context.Context, db *gorm.DB) error {
+	RegisterHandler("retention", retentionHandler(db))
+	_, err := RegisterSchedule(ctx, db, "retention", getEnv("RETENTION_SCHEDULE", "*/15 * * * *"), "retention", nil, 0)
+	return err
+}
+
+// retentionHandler moves or deletes old finished, dead and cancelled jobs
+// without an idempotency key along with their worker logs, in batches so no
+// transaction holds many row locks for long.
+func retentionHandler(db *gorm.DB) Handler {
+	return func(ctx context.Context, payload json.RawMessage) error {
+		var p retentionPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return Permanent(fmt.Errorf("invalid payload: %w", err))
+		}
+		if p.OlderThan == "" {
+			p.OlderThan = getEnv("RETENTION_AFTER", "7d")
+		}
+		if p.Mode == "" {
+			p.Mode = getEnv("RETENTION_MODE", "archive")
+		}
+		if p.BatchSize <= 0 {
+			p.BatchSize = getEnvInt("RETENTION_BATCH_SIZE", 500)
+		}
+
+		age, err := parseAge(p.OlderThan)
+		if err != nil {
+			return Permanent(err)
+		}
+		if p.Mode != "archive" && p.Mode != "delete" {
+			return Permanent(fmt.Errorf("unknown retention mode %q", p.Mode))
+		}
+
+		cutoff := time.Now().Add(-age)
+		var total int
+		for {
+			n, err := retainBatch(ctx, db, cutoff, p.Mode == "archive", p.BatchSize)
+			total += n
+			if err != nil {
+				return err
+			}
+			if n < p.BatchSize {
+				break
+			}
+		}
+
+		if total > 0 {
+			log.Printf("Retention : %sd %d job(s) last updated before %s\n", p.Mode, total, cutoff.Format(time.RFC3339))
+		}
+		return SetResult(ctx, map[string]any{"mode": p.Mode, "jobs": total, "cutoff": cutoff})
+	}
+}
+
+// retainBatch archives or deletes up to limit jobs last updated before
+// cutoff in one transaction, returning how many were removed from workers.
+// Their worker logs and dependencies go with them. Jobs with an idempotency
+// key are kept, the row is what makes enqueueing the key again return it.
+func retainBatch(ctx context.Context, db *gorm.DB, cutoff time.Time, archive bool, limit int) (int, error) {
+	var ids []uint
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Worker{}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND updated_at < ? AND idempotency_key IS NULL", retentionStatuses, cutoff).
+			Order("id").
+			Limit(limit).
+			Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		if archive {
+			if err := archiveRows(tx, "worker_logs", "worker_id IN ?", ids); err != nil {
+				return err
+			}
+			if err := archiveRows(tx, "workers", "id IN ?", ids); err != nil {
+				return err
+			}
+		}
+
+		// worker_logs and job_dependencies go through ON DELETE CASCADE.
+		return tx.Where("id IN ?", ids).Delete(&Worker{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// archiveRows copies the rows of table matching where into <table>_archive.
+// Columns are listed by name, columns added to both tables later end up in
+// another order.
+func archiveRows(tx *gorm.DB, table, where string, args ...any) error {
+	var columns string
+	if err := tx.Raw(`SELECT string_agg(quote_ident(column_name), ', ' ORDER BY ordinal_position)
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ?`, table).
+		Scan(&columns).Error; err != nil {
+		return err
+	}
+
+	return tx.Exec(fmt.Sprintf("INSERT INTO %s_archive (%s) SELECT %s FROM %s WHERE %s",
+		table, columns, columns, table, where), args...).Error
+}
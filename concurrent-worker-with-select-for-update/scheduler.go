@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Schedule enqueues a job of JobType every time CronExpr ticks.
+type Schedule struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Name      string          `gorm:"not null;uniqueIndex" json:"name"`
+	CronExpr  string          `gorm:"not null" json:"cron_expr"`
+	JobType   string          `gorm:"not null" json:"job_type"`
+	Payload   json.RawMessage `gorm:"type:jsonb;not null;default:'{}'" json:"payload"`
+	Priority  int             `gorm:"not null;default:0" json:"priority"`
+	Enabled   bool            `gorm:"not null;default:true" json:"enabled"`
+	NextRunAt time.Time       `gorm:"type:timestamptz;not null;default:now()" json:"next_run_at"`
+	LastRunAt *time.Time      `gorm:"type:timestamptz" json:"last_run_at,omitempty"`
+}
+
+// RegisterSchedule creates or updates the schedule called name, whose jobs
+// are enqueued with priority. The next tick is only recomputed when the cron
+// expression changes.
+func RegisterSchedule(ctx context.Context, db *gorm.DB, name, cronExpr, jobType string, payload any, priority int) (*Schedule, error) {
+	sched, err := cron.ParseStandard(cronExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := marshalPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := Schedule{
+		Name:      name,
+		CronExpr:  cronExpr,
+		JobType:   jobType,
+		Payload:   raw,
+		Priority:  priority,
+		Enabled:   true,
+		NextRunAt: sched.Next(time.Now()),
+	}
+
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"next_run_at": gorm.Expr("CASE WHEN schedules.cron_expr = EXCLUDED.cron_expr THEN schedules.next_run_at ELSE EXCLUDED.next_run_at END"),
+			"cron_expr":   gorm.Expr("EXCLUDED.cron_expr"),
+			"job_type":    gorm.Expr("EXCLUDED.job_type"),
+			"payload":     gorm.Expr("EXCLUDED.payload"),
+			"priority":    gorm.Expr("EXCLUDED.priority"),
+			"enabled":     true,
+		}),
+	}).Create(&schedule).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// runScheduler fires due schedules every SCHEDULER_INTERVAL. Every process
+// runs it, there is no leader.
+func runScheduler(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(getEnvDuration("SCHEDULER_INTERVAL", 10*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fired, err := fireSchedules(ctx, db)
+			if err != nil {
+				log.Println("Failed to fire schedules:", err)
+				continue
+			}
+			if fired > 0 {
+				log.Printf("Scheduler : Enqueued %d scheduled job(s)\n", fired)
+			}
+		}
+	}
+}
+
+// fireSchedules locks the due schedules with SKIP LOCKED, enqueues one job for
+// each and moves next_run_at past now in the same transaction. Whichever
+// process locks a schedule first is the only one to see it due, so each tick
+// enqueues exactly one job no matter how many processes run this. Missed ticks
+// are collapsed into a single run.
+func fireSchedules(ctx context.Context, db *gorm.DB) (int, error) {
+	fired := 0
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var schedules []Schedule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("enabled AND next_run_at <= ?", time.Now()).
+			Find(&schedules).Error; err != nil {
+			return err
+		}
+
+		for _, schedule := range schedules {
+			sched, err := cron.ParseStandard(schedule.CronExpr)
+			if err != nil {
+				log.Printf("Scheduler : Disabling schedule %s with invalid cron expression %q: %v\n", schedule.Name, schedule.CronExpr, err)
+				if err := tx.Model(&schedule).Update("enabled", false).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := Enqueue(ctx, tx, schedule.JobType, schedule.Payload, EnqueueOptions{
+				RunAt:    schedule.NextRunAt,
+				Priority: schedule.Priority,
+			}); err != nil {
+				return err
+			}
+
+			if err := tx.Model(&schedule).Updates(map[string]any{
+				"last_run_at": schedule.NextRunAt,
+				"next_run_at": sched.Next(time.Now()),
+			}).Error; err != nil {
+				return err
+			}
+			fired++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return fired, nil
+}
+
+// listSchedules returns every schedule by name.
+func listSchedules(ctx context.Context, db *gorm.DB) ([]Schedule, error) {
+	var schedules []Schedule
+	if err := db.WithContext(ctx).Order("name").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
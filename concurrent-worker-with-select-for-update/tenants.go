@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultTenant owns jobs enqueued without a tenant.
+const DefaultTenant = "default"
+
+// Fair claim strategies, picked with FAIR_CLAIM.
+const (
+	fairWeighted   = "weighted"
+	fairRoundRobin = "round-robin"
+	fairOff        = "off"
+)
+
+// Tenant sets the share of claims a tenant gets under the weighted strategy,
+// Weight jobs for every job of a tenant with weight 1. Tenants without a row
+// have weight 1.
+type Tenant struct {
+	Name   string `gorm:"primaryKey" json:"name"`
+	Weight int    `gorm:"not null;default:1" json:"weight"`
+}
+
+// tenantCursor moves the start of the rotation by one tenant per claim, so
+// batches of one still take turns.
+var tenantCursor atomic.Uint64
+
+// tenantRotation is the order one claim visits tenants in.
+type tenantRotation struct {
+	// order lists each tenant once per unit of weight, interleaved.
+	order   []string
+	tenants int
+	start   int
+}
+
+// loadTenantRotation returns the rotation over tenants with pending jobs for
+// one claim, or nil when FAIR_CLAIM is off.
+func loadTenantRotation(tx *gorm.DB) (*tenantRotation, error) {
+	strategy := getEnv("FAIR_CLAIM", fairWeighted)
+	if strategy == fairOff {
+		return nil, nil
+	}
+
+	// A loose index scan over workers_tenant_claim_idx, one probe per tenant
+	// instead of reading every pending row.
+	var tenants []Tenant
+	if err := tx.Raw(`WITH RECURSIVE pending AS (
+			(SELECT tenant_id FROM workers WHERE status = ? ORDER BY tenant_id LIMIT 1)
+			UNION ALL
+			SELECT (SELECT w.tenant_id FROM workers w WHERE w.status = ? AND w.tenant_id > pending.tenant_id ORDER BY w.tenant_id LIMIT 1)
+			FROM pending WHERE pending.tenant_id IS NOT NULL
+		)
+		SELECT pending.tenant_id AS name, COALESCE(t.weight, 1) AS weight
+		FROM pending LEFT JOIN tenants t ON t.name = pending.tenant_id
+		WHERE pending.tenant_id IS NOT NULL`, StatusPending, StatusPending).
+		Scan(&tenants).Error; err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, nil
+	}
+
+	rotation := &tenantRotation{tenants: len(tenants)}
+	for round := 0; ; round++ {
+		added := false
+		for _, tenant := range tenants {
+			if strategy == fairRoundRobin {
+				tenant.Weight = 1
+			}
+			if tenant.Weight > round {
+				rotation.order = append(rotation.order, tenant.Name)
+				added = true
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	rotation.start = int(tenantCursor.Add(1) % uint64(len(rotation.order)))
+	return rotation, nil
+}
+
+// selectDueFair locks up to limit due rows of query taking turns between
+// tenants, one job per visit, so a tenant with a large backlog cannot starve
+// the others. Priority only orders jobs within a tenant. Without a rotation,
+// or with pending jobs of a single tenant, it is the one query of selectDue.
+// Rows picked earlier in the claim stay pending until it commits, and SKIP
+// LOCKED does not skip rows the transaction locked itself, so every visit
+// leaves them out.
+func selectDueFair(query *gorm.DB, limit int, rotation *tenantRotation) ([]Worker, error) {
+	if rotation == nil || rotation.tenants < 2 {
+		return selectDue(query, limit)
+	}
+
+	var workers []Worker
+	var picked []uint
+	drained := map[string]bool{}
+	for i := 0; len(workers) < limit && len(drained) < rotation.tenants; i++ {
+		tenant := rotation.order[(rotation.start+i)%len(rotation.order)]
+		if drained[tenant] {
+			continue
+		}
+
+		visit := query.Session(&gorm.Session{}).Where("tenant_id = ?", tenant)
+		if len(picked) > 0 {
+			visit = visit.Where("id NOT IN ?", picked)
+		}
+		claimed, err := selectDue(visit, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(claimed) == 0 {
+			drained[tenant] = true
+			continue
+		}
+		workers = append(workers, claimed...)
+		picked = append(picked, claimed[0].ID)
+	}
+	return workers, nil
+}
+
+// SetTenantWeight creates or replaces the weight of a tenant.
+func SetTenantWeight(ctx context.Context, db *gorm.DB, name string, weight int) (*Tenant, error) {
+	if name == "" {
+		return nil, errors.New("tenant name is required")
+	}
+	if weight < 1 {
+		return nil, fmt.Errorf("weight %d must be at least 1", weight)
+	}
+
+	tenant := Tenant{Name: name, Weight: weight}
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&tenant).Error; err != nil {
+		return nil, err
+	}
+	return &tenant, nil
+}
+
+// listTenants returns every tenant with a weight, by name.
+func listTenants(ctx context.Context, db *gorm.DB) ([]Tenant, error) {
+	var tenants []Tenant
+	if err := db.WithContext(ctx).Order("name").Find(&tenants).Error; err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}